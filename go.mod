@@ -0,0 +1,31 @@
+module github.com/nalej/scylladb-utils
+
+go 1.18
+
+require (
+	github.com/gocql/gocql v0.0.0-20200526081602-cd04bd7f22a7
+	github.com/google/uuid v1.1.1
+	github.com/nalej/derrors v2.1.0+incompatible
+	github.com/nalej/grpc-utils v1.5.0
+	github.com/onsi/ginkgo v1.10.3
+	github.com/onsi/gomega v1.7.1
+	github.com/rs/zerolog v1.16.0
+	github.com/scylladb/go-reflectx v1.0.1
+	github.com/scylladb/gocqlx v1.3.1
+)
+
+require (
+	github.com/golang/protobuf v1.3.2 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859 // indirect
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
+	golang.org/x/text v0.3.0 // indirect
+	google.golang.org/genproto v0.0.0-20191028173616-919d9bdd9fe6 // indirect
+	google.golang.org/grpc v1.25.0 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/inf.v0 v0.9.1 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.4 // indirect
+)
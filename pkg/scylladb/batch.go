@@ -0,0 +1,268 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/go-reflectx"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"reflect"
+	"sort"
+)
+
+// A batch accumulates inserts, updates and deletes that are sent together. A LOGGED batch guarantees that all its
+// statements are eventually applied, and an UNLOGGED batch only saves round trips. Batches spanning many partitions
+// overload the coordinator, so a warning is logged when the number of partitions exceeds PartitionsWarning. The
+// partitions are counted by the key passed to each statement, so the count is an upper bound if the key contains
+// clustering columns, except for the statements driven by the table metadata.
+
+// DefaultBatchPartitionsWarning is the number of partitions a batch may span before a warning is logged.
+const DefaultBatchPartitionsWarning = 10
+
+// Batch contains the statements of a batch and the values bound to them.
+type Batch struct {
+	// PartitionsWarning is the number of partitions the batch may span before a warning is logged.
+	PartitionsWarning int
+	db                *ScyllaDB
+	batchType         gocql.BatchType
+	statements        []batchStatement
+	// partitions contains the partitions modified by the batch, identified by table and key.
+	partitions map[string]bool
+	// err contains the first error found while adding the statements.
+	err derrors.Error
+}
+
+// batchStatement contains a statement of a batch and its values.
+type batchStatement struct {
+	stmt   string
+	values []interface{}
+}
+
+// NewBatch creates an empty batch of the given type, gocql.LoggedBatch or gocql.UnloggedBatch.
+func (s *ScyllaDB) NewBatch(batchType gocql.BatchType) *Batch {
+	return &Batch{
+		PartitionsWarning: DefaultBatchPartitionsWarning,
+		db:                s,
+		batchType:         batchType,
+		statements:        make([]batchStatement, 0),
+		partitions:        make(map[string]bool, 0),
+	}
+}
+
+// Size returns the number of statements of the batch.
+func (b *Batch) Size() int {
+	return len(b.statements)
+}
+
+// Partitions returns the number of partitions modified by the batch.
+func (b *Batch) Partitions() int {
+	return len(b.partitions)
+}
+
+// Add inserts the columns of an element identified by a composite primary key.
+func (b *Batch) Add(table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}) *Batch {
+	return b.insert(gocqlx.DefaultMapper, table, pkColumn, tableColumnNames, toAdd)
+}
+
+// insert adds an insert statement, binding the columns from the fields of toAdd resolved by the mapper.
+func (b *Batch) insert(mapper *reflectx.Mapper, table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}) *Batch {
+	stmt, names := qb.Insert(table).Columns(tableColumnNames...).ToCql()
+	values, err := bindStruct(mapper, names, toAdd, pkColumn)
+	return b.add(table, pkColumn, stmt, values, err)
+}
+
+// Update updates the columns of an element identified by a composite primary key.
+func (b *Batch) Update(table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}) *Batch {
+	return b.update(gocqlx.DefaultMapper, table, pkColumn, tableColumnNames, toUpdate)
+}
+
+// update adds an update statement, binding the columns from the fields of toUpdate resolved by the mapper.
+func (b *Batch) update(mapper *reflectx.Mapper, table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}) *Batch {
+	sb := qb.Update(table).Set(tableColumnNames...)
+	for _, p := range sortedKeys(pkColumn) {
+		sb = sb.Where(qb.Eq(p))
+	}
+	stmt, names := sb.ToCql()
+	values, err := bindStruct(mapper, names, toUpdate, pkColumn)
+	return b.add(table, pkColumn, stmt, values, err)
+}
+
+// Remove removes an element identified by a composite primary key.
+func (b *Batch) Remove(table string, pkColumn map[string]interface{}) *Batch {
+	sb := qb.Delete(table)
+	keys := sortedKeys(pkColumn)
+	values := make([]interface{}, 0, len(keys))
+	for _, p := range keys {
+		sb = sb.Where(qb.Eq(p))
+		values = append(values, pkColumn[p])
+	}
+	stmt, _ := sb.ToCql()
+	return b.add(table, pkColumn, stmt, values, nil)
+}
+
+// EntityAdd inserts all the columns of an entity.
+func (b *Batch) EntityAdd(md *TableMetadata, toAdd interface{}) *Batch {
+	key, err := md.KeyValues(toAdd)
+	if err != nil {
+		return b.fail(err)
+	}
+	b.insert(md.mapper, md.Table, key, md.Columns, toAdd)
+	return b.setPartition(md, key)
+}
+
+// EntityUpdate updates all the columns of an entity that do not belong to the primary key.
+func (b *Batch) EntityUpdate(md *TableMetadata, toUpdate interface{}) *Batch {
+	key, err := md.KeyValues(toUpdate)
+	if err != nil {
+		return b.fail(err)
+	}
+	b.update(md.mapper, md.Table, key, md.NonKeyColumns(), toUpdate)
+	return b.setPartition(md, key)
+}
+
+// EntityRemove removes the element with the primary key of an entity.
+func (b *Batch) EntityRemove(md *TableMetadata, toRemove interface{}) *Batch {
+	key, err := md.KeyValues(toRemove)
+	if err != nil {
+		return b.fail(err)
+	}
+	b.Remove(md.Table, key)
+	return b.setPartition(md, key)
+}
+
+// Exec executes the batch. Nothing is executed if an error was found while adding the statements.
+func (b *Batch) Exec() derrors.Error {
+	return b.ExecContext(context.Background())
+}
+
+// ExecContext is the context-aware version of Exec. The batch is retried following the policy of BatchOperation
+// if all its statements are idempotent.
+func (b *Batch) ExecContext(ctx context.Context) derrors.Error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.statements) == 0 {
+		return nil
+	}
+	// check connection
+	session, err := b.db.getSession(ctx)
+	if err != nil {
+		return err
+	}
+	if b.PartitionsWarning > 0 && len(b.partitions) > b.PartitionsWarning {
+		log.Warn().Int("partitions", len(b.partitions)).Int("statements", len(b.statements)).Msg("batch spans many partitions")
+	}
+
+	idempotent := b.batchType != gocql.CounterBatch
+	for _, st := range b.statements {
+		idempotent = idempotent && IsIdempotent(st.stmt)
+	}
+	policy := b.db.retryPolicy(BatchOperation)
+	return withParams(retry(ctx, policy, BatchOperation, idempotent, "cannot execute batch", func(attempt int) error {
+		batch := session.NewBatch(b.batchType).WithContext(ctx)
+		if consistency, downgrade := policy.consistency(attempt); downgrade {
+			batch.SetConsistency(consistency)
+		}
+		for _, st := range b.statements {
+			batch.Query(st.stmt, st.values...)
+		}
+		return session.ExecuteBatch(batch)
+	}), len(b.statements))
+}
+
+// add adds a statement modifying the partition of a key.
+func (b *Batch) add(table string, key map[string]interface{}, stmt string, values []interface{}, err derrors.Error) *Batch {
+	if err != nil {
+		return b.fail(withParams(err, table))
+	}
+	b.statements = append(b.statements, batchStatement{stmt: stmt, values: values})
+	b.partitions[partitionID(table, key, sortedKeys(key))] = true
+	return b
+}
+
+// setPartition replaces the partition of the last statement, identified by its whole key, with the partition of
+// the table metadata.
+func (b *Batch) setPartition(md *TableMetadata, key map[string]interface{}) *Batch {
+	if b.err != nil {
+		return b
+	}
+	delete(b.partitions, partitionID(md.Table, key, sortedKeys(key)))
+	b.partitions[partitionID(md.Table, key, md.PartitionKey)] = true
+	return b
+}
+
+// fail records the first error found.
+func (b *Batch) fail(err derrors.Error) *Batch {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+// bindStruct returns the values of the named parameters of a statement, taken from the fields of a struct resolved
+// by the mapper or from the key if the struct has no field for them.
+func bindStruct(mapper *reflectx.Mapper, names []string, arg interface{}, key map[string]interface{}) ([]interface{}, derrors.Error) {
+	value := reflect.ValueOf(arg)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, derrors.NewInvalidArgumentError("batch values must be structs").WithParams(reflect.TypeOf(arg))
+	}
+	values := make([]interface{}, 0, len(names))
+	err := mapper.TraversalsByNameFunc(value.Type(), names, func(i int, traversal []int) error {
+		if len(traversal) != 0 {
+			values = append(values, reflectx.FieldByIndexesReadOnly(value, traversal).Interface())
+			return nil
+		}
+		keyValue, exists := key[names[i]]
+		if !exists {
+			return fmt.Errorf("column %q not found in %s", names[i], value.Type())
+		}
+		values = append(values, keyValue)
+		return nil
+	})
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("cannot bind batch values", err)
+	}
+	return values, nil
+}
+
+// partitionID identifies the partition of a table with the values of the given key columns.
+func partitionID(table string, key map[string]interface{}, columns []string) string {
+	values := make([]interface{}, 0, len(columns))
+	for _, c := range columns {
+		values = append(values, key[c])
+	}
+	return fmt.Sprintf("%s%v", table, values)
+}
+
+// sortedKeys returns the columns of a key sorted by name, so the statements do not depend on the map order.
+func sortedKeys(key map[string]interface{}) []string {
+	keys := make([]string, 0, len(key))
+	for k := range key {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Batches", func() {
+
+	ginkgo.It("should bind the values of the statements", func() {
+		compo := &CompositeStruct{Id1: "a", Id2: "b", Id3: "c"}
+		batch := (&ScyllaDB{}).NewBatch(gocql.LoggedBatch).
+			Add(Table, GetCompositeValues(*compo), AllTableColumns, compo).
+			Update(Table, GetCompositeValues(*compo), AllCompositeTableColumnsNoPK, compo).
+			Remove(BasicTable, map[string]interface{}{"id1": "d"})
+		gomega.Expect(batch.err).Should(gomega.BeNil())
+		gomega.Expect(batch.Size()).Should(gomega.Equal(3))
+		gomega.Expect(batch.statements).Should(gomega.Equal([]batchStatement{
+			{stmt: "INSERT INTO tabletest (id1,id2,id3) VALUES (?,?,?) ", values: []interface{}{"a", "b", "c"}},
+			{stmt: "UPDATE tabletest SET id3=? WHERE id1=? AND id2=? ", values: []interface{}{"c", "a", "b"}},
+			{stmt: "DELETE FROM basictabletest WHERE id1=? ", values: []interface{}{"d"}},
+		}))
+		gomega.Expect(batch.Partitions()).Should(gomega.Equal(2))
+	})
+	ginkgo.It("should count the partitions of the table metadata", func() {
+		md, err := NewTableMetadataWithKeys(Table, &CompositeStruct{}, []string{"id1"}, []string{"id2"})
+		gomega.Expect(err).To(gomega.Succeed())
+		batch := (&ScyllaDB{}).NewBatch(gocql.UnloggedBatch).
+			EntityAdd(md, &CompositeStruct{Id1: "a", Id2: "b"}).
+			EntityAdd(md, &CompositeStruct{Id1: "a", Id2: "c"}).
+			EntityRemove(md, &CompositeStruct{Id1: "d", Id2: "e"})
+		gomega.Expect(batch.Size()).Should(gomega.Equal(3))
+		gomega.Expect(batch.Partitions()).Should(gomega.Equal(2))
+	})
+	ginkgo.It("should return the binding errors when executed", func() {
+		batch := (&ScyllaDB{}).NewBatch(gocql.LoggedBatch).
+			Add(Table, nil, []string{"id1", "missing"}, &CompositeStruct{}).
+			Remove(Table, map[string]interface{}{"id1": "a"})
+		gomega.Expect(batch.Size()).Should(gomega.Equal(1))
+		err := batch.Exec()
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.InvalidArgument))
+	})
+	ginkgo.It("should not execute empty batches", func() {
+		gomega.Expect((&ScyllaDB{}).NewBatch(gocql.LoggedBatch).Exec()).To(gomega.Succeed())
+	})
+})
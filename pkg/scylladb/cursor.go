@@ -0,0 +1,195 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/nalej/derrors"
+	"sort"
+)
+
+// Cursors are the page tokens handed out to the clients of the list operations. The gocql paging state is
+// encrypted together with the table name and the fingerprint of the query, and the result is authenticated
+// with an HMAC. Clients cannot read nor forge them, and a cursor obtained for a query is rejected if it is
+// used with a different one.
+
+// InvalidCursorMsg corresponds to the error message returned when a cursor cannot be decoded.
+const InvalidCursorMsg = "invalid cursor"
+
+// cursorVersion is the first byte of every cursor, to be able to change the format in the future.
+const cursorVersion byte = 1
+
+// CursorCodec encodes and decodes cursors using a secret shared by all the replicas of a service.
+type CursorCodec struct {
+	encryptionKey     []byte
+	authenticationKey []byte
+}
+
+// MinCursorSecretLength is the minimum length in bytes of the secret of a CursorCodec.
+const MinCursorSecretLength = 32
+
+// NewCursorCodec creates a CursorCodec. The encryption and authentication keys are derived from the secret, that
+// must be at least MinCursorSecretLength bytes long.
+func NewCursorCodec(secret []byte) (*CursorCodec, derrors.Error) {
+	if len(secret) < MinCursorSecretLength {
+		return nil, derrors.NewInvalidArgumentError("cursor secret is too short").WithParams(len(secret), MinCursorSecretLength)
+	}
+	return &CursorCodec{
+		encryptionKey:     deriveKey(secret, "cursor-encryption"),
+		authenticationKey: deriveKey(secret, "cursor-authentication"),
+	}, nil
+}
+
+// deriveKey derives a 256 bits key for a given purpose from the secret.
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// QueryFingerprint returns an identifier of a list query built from the table, the partition key values and the
+// columns being retrieved.
+func QueryFingerprint(table string, pkColumn map[string]interface{}, tableColumnNames []string) string {
+	pkNames := make([]string, 0, len(pkColumn))
+	for p := range pkColumn {
+		pkNames = append(pkNames, p)
+	}
+	sort.Strings(pkNames)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "table=%q;", table)
+	for _, p := range pkNames {
+		fmt.Fprintf(hash, "pk=%q:%v;", p, pkColumn[p])
+	}
+	for _, c := range tableColumnNames {
+		fmt.Fprintf(hash, "column=%q;", c)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Encode builds the cursor of a page state obtained for a query over a table.
+func (c *CursorCodec) Encode(table string, fingerprint string, pageState []byte) (string, derrors.Error) {
+	var plain bytes.Buffer
+	for _, field := range [][]byte{[]byte(table), []byte(fingerprint), pageState} {
+		if err := binary.Write(&plain, binary.BigEndian, uint32(len(field))); err != nil {
+			return "", derrors.AsError(err, "cannot encode cursor")
+		}
+		plain.Write(field)
+	}
+
+	block, err := aes.NewCipher(c.encryptionKey)
+	if err != nil {
+		return "", derrors.AsError(err, "cannot encode cursor")
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", derrors.AsError(err, "cannot encode cursor")
+	}
+	encrypted := make([]byte, plain.Len())
+	cipher.NewCTR(block, iv).XORKeyStream(encrypted, plain.Bytes())
+
+	cursor := make([]byte, 0, 1+len(iv)+len(encrypted)+sha256.Size)
+	cursor = append(cursor, cursorVersion)
+	cursor = append(cursor, iv...)
+	cursor = append(cursor, encrypted...)
+	cursor = append(cursor, c.sign(cursor)...)
+
+	return base64.RawURLEncoding.EncodeToString(cursor), nil
+}
+
+// Decode returns the page state of a cursor, checking that it was built by a codec with the same secret for the same
+// table and query.
+func (c *CursorCodec) Decode(cursor string, table string, fingerprint string) ([]byte, derrors.Error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError(InvalidCursorMsg, err)
+	}
+	if len(raw) < 1+aes.BlockSize+sha256.Size || raw[0] != cursorVersion {
+		return nil, derrors.NewInvalidArgumentError(InvalidCursorMsg)
+	}
+	signed, signature := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	if !hmac.Equal(signature, c.sign(signed)) {
+		return nil, derrors.NewInvalidArgumentError(InvalidCursorMsg)
+	}
+
+	block, err := aes.NewCipher(c.encryptionKey)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot decode cursor")
+	}
+	iv, encrypted := signed[1:1+aes.BlockSize], signed[1+aes.BlockSize:]
+	plain := make([]byte, len(encrypted))
+	cipher.NewCTR(block, iv).XORKeyStream(plain, encrypted)
+
+	fields := make([][]byte, 0, 3)
+	reader := bytes.NewReader(plain)
+	for i := 0; i < 3; i++ {
+		var length uint32
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil || int(length) > reader.Len() {
+			return nil, derrors.NewInvalidArgumentError(InvalidCursorMsg)
+		}
+		field := make([]byte, length)
+		reader.Read(field)
+		fields = append(fields, field)
+	}
+
+	if string(fields[0]) != table || string(fields[1]) != fingerprint {
+		return nil, derrors.NewInvalidArgumentError("cursor does not belong to this query").WithParams(table)
+	}
+	return fields[2], nil
+}
+
+// sign returns the HMAC of the given data.
+func (c *CursorCodec) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.authenticationKey)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// UnsafeListWithCursor retrieves a page of elements from a table like UnsafeList, but receives and returns cursors
+// encoded with codec instead of raw page states. The first page is requested with an empty cursor, and an empty
+// cursor is returned when there are no more pages.
+func (s *ScyllaDB) UnsafeListWithCursor(codec *CursorCodec, table string, pkColumn map[string]interface{}, tableColumnNames []string, pageSize int, cursor string, results interface{}) (string, derrors.Error) {
+	fingerprint := QueryFingerprint(table, pkColumn, tableColumnNames)
+
+	var pageToken []byte
+	if cursor != "" {
+		decoded, err := codec.Decode(cursor, table, fingerprint)
+		if err != nil {
+			return "", err
+		}
+		pageToken = decoded
+	}
+
+	nextToken, err := s.UnsafeList(table, pkColumn, tableColumnNames, pageSize, pageToken, results)
+	if err != nil {
+		return "", err
+	}
+	if nextToken == nil {
+		return "", nil
+	}
+	return codec.Encode(table, fingerprint, nextToken)
+}
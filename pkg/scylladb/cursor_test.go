@@ -0,0 +1,76 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Cursor codec", func() {
+
+	codec, _ := NewCursorCodec([]byte("a secret of at least thirty-two bytes"))
+	pageState := []byte("page-state")
+	fingerprint := QueryFingerprint(Table, map[string]interface{}{"id1": "value"}, AllTableColumns)
+
+	ginkgo.It("should be able to decode an encoded cursor", func() {
+		cursor, err := codec.Encode(Table, fingerprint, pageState)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(cursor).ShouldNot(gomega.ContainSubstring(string(pageState)))
+
+		decoded, err := codec.Decode(cursor, Table, fingerprint)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(decoded).Should(gomega.Equal(pageState))
+	})
+	ginkgo.It("should not be able to decode a cursor of another query", func() {
+		cursor, err := codec.Encode(Table, fingerprint, pageState)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		_, err = codec.Decode(cursor, BasicTable, fingerprint)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		other := QueryFingerprint(Table, map[string]interface{}{"id1": "other"}, AllTableColumns)
+		_, err = codec.Decode(cursor, Table, other)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+	ginkgo.It("should not be able to decode a tampered cursor", func() {
+		cursor, err := codec.Encode(Table, fingerprint, pageState)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		tampered := []byte(cursor)
+		tampered[len(tampered)/2] ^= 1
+		_, err = codec.Decode(string(tampered), Table, fingerprint)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		other, err := NewCursorCodec([]byte("another secret of thirty-two bytes"))
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = other.Decode(cursor, Table, fingerprint)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		_, err = codec.Decode("not a cursor", Table, fingerprint)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+	ginkgo.It("should not be able to create a codec with a short secret", func() {
+		for _, secret := range [][]byte{nil, {}, []byte("secret")} {
+			_, err := NewCursorCodec(secret)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.InvalidArgument))
+		}
+	})
+})
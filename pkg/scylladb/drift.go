@@ -0,0 +1,236 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// The schema of a table is validated against the metadata of the keyspace read by the session. The types are
+// compared in their CQL form without frozen modifiers, and the types that gocql marshals from the same Go types are
+// considered equal: text and ascii, uuid and timeuuid, bigint and counter, and timestamp and date.
+
+// SchemaDriftMsg is the message of the error returned when the schema of a table does not match the expected one.
+const SchemaDriftMsg = "table schema does not match"
+
+// equivalentTypes maps the CQL types to the type they are compared as.
+var equivalentTypes = map[string]string{
+	"varchar":  "text",
+	"ascii":    "text",
+	"timeuuid": "uuid",
+	"counter":  "bigint",
+	"date":     "timestamp",
+}
+
+// typeNameRegexp matches the names inside a CQL type.
+var typeNameRegexp = regexp.MustCompile(`[a-z_][a-z0-9_]*`)
+
+// ColumnTypeMismatch contains a column whose type is not the expected one.
+type ColumnTypeMismatch struct {
+	Column   string
+	Expected string
+	Actual   string
+}
+
+// SchemaDrift contains the differences between the expected schema of a table and the schema in the database.
+type SchemaDrift struct {
+	// Table is the name of the table.
+	Table string
+	// MissingTable is true if the table does not exist.
+	MissingTable bool
+	// MissingColumns contains the expected columns that do not exist.
+	MissingColumns []string
+	// TypeMismatches contains the columns whose type is not the expected one.
+	TypeMismatches []ColumnTypeMismatch
+	// ExpectedPartitionKey and ActualPartitionKey are set if the partition key is not the expected one.
+	ExpectedPartitionKey []string
+	ActualPartitionKey   []string
+	// ExpectedClusteringKey and ActualClusteringKey are set if the clustering key is not the expected one.
+	ExpectedClusteringKey []string
+	ActualClusteringKey   []string
+}
+
+// HasDrift checks if there is any difference.
+func (d *SchemaDrift) HasDrift() bool {
+	return d.MissingTable || len(d.MissingColumns) > 0 || len(d.TypeMismatches) > 0 ||
+		d.ExpectedPartitionKey != nil || d.ExpectedClusteringKey != nil
+}
+
+// Error returns a FailedPrecondition error describing the differences, or nil if there are none.
+func (d *SchemaDrift) Error() derrors.Error {
+	if !d.HasDrift() {
+		return nil
+	}
+	err := derrors.NewFailedPreconditionError(SchemaDriftMsg).WithParams(d.Table)
+	if d.MissingTable {
+		return err.WithParams("missing table")
+	}
+	if len(d.MissingColumns) > 0 {
+		err = err.WithParams("missing columns", d.MissingColumns)
+	}
+	for _, m := range d.TypeMismatches {
+		err = err.WithParams("type mismatch", m.Column, m.Expected, m.Actual)
+	}
+	if d.ExpectedPartitionKey != nil {
+		err = err.WithParams("partition key mismatch", d.ExpectedPartitionKey, d.ActualPartitionKey)
+	}
+	if d.ExpectedClusteringKey != nil {
+		err = err.WithParams("clustering key mismatch", d.ExpectedClusteringKey, d.ActualClusteringKey)
+	}
+	return err
+}
+
+// ValidateTable compares the table of the metadata with the table in the keyspace of the session, checking the
+// columns, their types and the primary key.
+func (s *ScyllaDB) ValidateTable(md *TableMetadata) (*SchemaDrift, derrors.Error) {
+	table, err := s.tableSchema(md.Table)
+	if err != nil {
+		return nil, err
+	}
+	return CompareTableSchema(md, table)
+}
+
+// ValidateColumns checks that the columns exist in a table of the keyspace of the session.
+func (s *ScyllaDB) ValidateColumns(table string, tableColumnNames []string) (*SchemaDrift, derrors.Error) {
+	schema, err := s.tableSchema(table)
+	if err != nil {
+		return nil, err
+	}
+	drift := &SchemaDrift{Table: table}
+	if schema == nil {
+		drift.MissingTable = true
+		return drift, nil
+	}
+	for _, c := range tableColumnNames {
+		if _, exists := schema.Columns[strings.ToLower(c)]; !exists {
+			drift.MissingColumns = append(drift.MissingColumns, c)
+		}
+	}
+	return drift, nil
+}
+
+// CompareTableSchema compares the table of the metadata with the schema of a table read by gocql, that is nil if the
+// table does not exist.
+func CompareTableSchema(md *TableMetadata, table *gocql.TableMetadata) (*SchemaDrift, derrors.Error) {
+	drift := &SchemaDrift{Table: md.Table}
+	if table == nil {
+		drift.MissingTable = true
+		return drift, nil
+	}
+
+	types := &userTypes{names: make(map[string]reflect.Type, 0)}
+	for _, c := range md.Columns {
+		expected, err := types.cqlType(md.fields[c].Type, false)
+		if err != nil {
+			return nil, withParams(err, md.Table, c)
+		}
+		column, exists := table.Columns[strings.ToLower(c)]
+		if !exists {
+			drift.MissingColumns = append(drift.MissingColumns, c)
+			continue
+		}
+		if normalizeType(expected) != normalizeType(column.Validator) {
+			drift.TypeMismatches = append(drift.TypeMismatches, ColumnTypeMismatch{Column: c, Expected: expected, Actual: column.Validator})
+		}
+	}
+
+	actualPartitionKey := columnNames(table.PartitionKey)
+	if !equalColumns(md.PartitionKey, actualPartitionKey) {
+		drift.ExpectedPartitionKey = md.PartitionKey
+		drift.ActualPartitionKey = actualPartitionKey
+	}
+	actualClusteringKey := columnNames(table.ClusteringColumns)
+	if !equalColumns(md.ClusteringKey, actualClusteringKey) {
+		drift.ExpectedClusteringKey = md.ClusteringKey
+		drift.ActualClusteringKey = actualClusteringKey
+	}
+	return drift, nil
+}
+
+// tableSchema reads the schema of a table of the keyspace of the session, returning nil if it does not exist.
+func (s *ScyllaDB) tableSchema(table string) (*gocql.TableMetadata, derrors.Error) {
+	// check connection
+	session, err := s.getSession(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	keyspace := s.ClusterConfig().Keyspace
+	metadata, cqlErr := session.KeyspaceMetadata(keyspace)
+	if cqlErr != nil {
+		return nil, withParams(TranslateError(context.Background(), cqlErr, "cannot read keyspace metadata"), keyspace)
+	}
+	return metadata.Tables[strings.ToLower(table)], nil
+}
+
+// normalizeType returns a CQL type without frozen modifiers nor spaces, and with the equivalent types replaced.
+func normalizeType(cqlType string) string {
+	cqlType = strings.Replace(strings.ToLower(cqlType), " ", "", -1)
+	var result strings.Builder
+	// frozen contains, for each open bracket, whether it belongs to a frozen modifier
+	frozen := make([]bool, 0)
+	for i := 0; i < len(cqlType); i++ {
+		switch {
+		case strings.HasPrefix(cqlType[i:], "frozen<"):
+			frozen = append(frozen, true)
+			i += len("frozen<") - 1
+		case cqlType[i] == '<':
+			frozen = append(frozen, false)
+			result.WriteByte('<')
+		case cqlType[i] == '>' && len(frozen) > 0:
+			if !frozen[len(frozen)-1] {
+				result.WriteByte('>')
+			}
+			frozen = frozen[:len(frozen)-1]
+		default:
+			result.WriteByte(cqlType[i])
+		}
+	}
+	return typeNameRegexp.ReplaceAllStringFunc(result.String(), func(name string) string {
+		if equivalent, exists := equivalentTypes[name]; exists {
+			return equivalent
+		}
+		return name
+	})
+}
+
+// columnNames returns the names of a list of columns.
+func columnNames(columns []*gocql.ColumnMetadata) []string {
+	names := make([]string, 0, len(columns))
+	for _, c := range columns {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// equalColumns compares two lists of column names ignoring the case.
+func equalColumns(expected []string, actual []string) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for i := range expected {
+		if !strings.EqualFold(expected[i], actual[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// tableSchemaOf builds the gocql schema of a table from its columns and types, the first ones being the partition
+// key and the clustering key.
+func tableSchemaOf(partitionKey int, clusteringKey int, columns ...string) *gocql.TableMetadata {
+	table := &gocql.TableMetadata{Columns: make(map[string]*gocql.ColumnMetadata, 0)}
+	for i := 0; i < len(columns); i += 2 {
+		column := &gocql.ColumnMetadata{Name: columns[i], Validator: columns[i+1]}
+		table.Columns[column.Name] = column
+		if i/2 < partitionKey {
+			table.PartitionKey = append(table.PartitionKey, column)
+		} else if i/2 < partitionKey+clusteringKey {
+			table.ClusteringColumns = append(table.ClusteringColumns, column)
+		}
+	}
+	return table
+}
+
+var _ = ginkgo.Describe("Schema drift", func() {
+
+	var md *TableMetadata
+	ginkgo.BeforeEach(func() {
+		var err derrors.Error
+		md, err = NewTableMetadata(SchemaTable, &SchemaStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should normalize the types", func() {
+		gomega.Expect(normalizeType("frozen<list<frozen<schema_address>>>")).Should(gomega.Equal("list<schema_address>"))
+		gomega.Expect(normalizeType("map<varchar, frozen<list<text>>>")).Should(gomega.Equal("map<text,list<text>>"))
+		gomega.Expect(normalizeType("timeuuid")).Should(gomega.Equal("uuid"))
+	})
+	ginkgo.It("should not report differences in an equal schema", func() {
+		table := tableSchemaOf(2, 1,
+			"organization_id", "timeuuid", "region", "varchar", "created", "timestamp", "name", "text",
+			"enabled", "boolean", "replicas", "int", "size", "counter", "ratio", "double", "data", "blob", "ip", "inet",
+			"labels", "map<text, text>", "ports", "list<bigint>", "groups", "map<text, frozen<list<text>>>",
+			"contact", "frozen<schema_contact>", "contacts", "map<text, frozen<schema_contact>>", "other", "text")
+		drift, err := CompareTableSchema(md, table)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(drift.HasDrift()).Should(gomega.BeFalse())
+		gomega.Expect(drift.Error()).Should(gomega.BeNil())
+	})
+	ginkgo.It("should report a missing table", func() {
+		drift, err := CompareTableSchema(md, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(drift.MissingTable).Should(gomega.BeTrue())
+		gomega.Expect(drift.Error()).ShouldNot(gomega.BeNil())
+	})
+	ginkgo.It("should report missing columns, type mismatches and key differences", func() {
+		tagged, err := NewTableMetadata(Table, &TaggedStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+		table := tableSchemaOf(2, 0, "id1", "text", "id2", "text", "id3", "int")
+		drift, err := CompareTableSchema(tagged, table)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(drift.HasDrift()).Should(gomega.BeTrue())
+		gomega.Expect(drift.MissingColumns).Should(gomega.BeEmpty())
+		gomega.Expect(drift.TypeMismatches).Should(gomega.Equal([]ColumnTypeMismatch{{Column: "id3", Expected: "text", Actual: "int"}}))
+		gomega.Expect(drift.ExpectedPartitionKey).Should(gomega.Equal([]string{"id1"}))
+		gomega.Expect(drift.ActualPartitionKey).Should(gomega.Equal([]string{"id1", "id2"}))
+		gomega.Expect(drift.ExpectedClusteringKey).Should(gomega.Equal([]string{"id2"}))
+		gomega.Expect(drift.ActualClusteringKey).Should(gomega.BeEmpty())
+		gomega.Expect(drift.Error().Type()).Should(gomega.Equal(derrors.NewFailedPreconditionError("").Type()))
+
+		drift, err = CompareTableSchema(tagged, tableSchemaOf(1, 1, "id1", "text", "id2", "text"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(drift.MissingColumns).Should(gomega.Equal([]string{"id3"}))
+		gomega.Expect(drift.ExpectedPartitionKey).Should(gomega.BeNil())
+		gomega.Expect(drift.ExpectedClusteringKey).Should(gomega.BeNil())
+	})
+})
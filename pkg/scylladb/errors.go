@@ -0,0 +1,191 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"net"
+)
+
+// The errors returned by gocql are translated into derrors with the type that best describes them. The errors that
+// may succeed if the operation is repeated carry the RetryableParam parameter, which is kept when the error is
+// serialized, so IsRetryable can be used by the callers on both sides of a gRPC call. Write timeouts of lightweight
+// transactions and counters are never retryable, as the write may have been applied.
+
+// RetryableParam is the parameter added to the errors that can be retried.
+const RetryableParam = "retryable"
+
+// Codes of the errors of the CQL native protocol.
+const (
+	cqlServerError    = 0x0000
+	cqlProtocolError  = 0x000A
+	cqlBadCredentials = 0x0100
+	cqlOverloaded     = 0x1001
+	cqlBootstrapping  = 0x1002
+	cqlTruncateError  = 0x1003
+	cqlSyntaxError    = 0x2000
+	cqlUnauthorized   = 0x2100
+	cqlInvalid        = 0x2200
+	cqlConfigError    = 0x2300
+)
+
+// Write types of the write timeouts whose write may have been applied.
+const (
+	casWriteType     = "CAS"
+	counterWriteType = "COUNTER"
+)
+
+// retryableParam is the serialized form of RetryableParam.
+var retryableParam = func() string {
+	ser, _ := json.Marshal(RetryableParam)
+	return string(ser)
+}()
+
+// TranslateError converts an error returned by a query into a derrors.Error of the type that corresponds to it,
+// distinguishing the cancellation and the expiration of the context from the rest of errors. It returns nil if the
+// error is nil.
+func TranslateError(ctx context.Context, err error, msg string) derrors.Error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.Canceled || errors.Is(err, context.Canceled) {
+		return derrors.NewCanceledError(msg, err)
+	}
+	if ctx.Err() == context.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		return derrors.NewDeadlineExceededError(msg, err)
+	}
+
+	switch {
+	case errors.Is(err, gocql.ErrNotFound), errors.Is(err, gocql.ErrKeyspaceDoesNotExist):
+		return derrors.NewNotFoundError(msg, err)
+	case errors.Is(err, gocql.ErrTimeoutNoResponse), errors.Is(err, gocql.ErrTooManyTimeouts):
+		return derrors.NewDeadlineExceededError(msg, err).WithParams(RetryableParam)
+	case errors.Is(err, gocql.ErrNoConnections), errors.Is(err, gocql.ErrConnectionClosed),
+		errors.Is(err, gocql.ErrNoStreams), errors.Is(err, gocql.ErrUnavailable):
+		return derrors.NewUnavailableError(msg, err).WithParams(RetryableParam)
+	case errors.Is(err, gocql.ErrSessionClosed):
+		return derrors.NewUnavailableError(msg, err)
+	case errors.Is(err, gocql.ErrNoKeyspace), errors.Is(err, gocql.ErrTooManyStmts), errors.Is(err, gocql.ErrUseStmt),
+		errors.Is(err, gocql.ErrQueryArgLength), errors.Is(err, gocql.ErrNoHosts):
+		return derrors.NewInvalidArgumentError(msg, err)
+	}
+
+	var readTimeout *gocql.RequestErrReadTimeout
+	if errors.As(err, &readTimeout) {
+		return derrors.NewDeadlineExceededError(msg, err).WithParams(readTimeout.Consistency.String(),
+			readTimeout.Received, readTimeout.BlockFor, RetryableParam)
+	}
+	var writeTimeout *gocql.RequestErrWriteTimeout
+	if errors.As(err, &writeTimeout) {
+		if writeTimeout.WriteType == casWriteType || writeTimeout.WriteType == counterWriteType {
+			return derrors.NewAbortedError(msg, err).WithParams(writeTimeout.Consistency.String(),
+				writeTimeout.Received, writeTimeout.BlockFor, writeTimeout.WriteType)
+		}
+		return derrors.NewDeadlineExceededError(msg, err).WithParams(writeTimeout.Consistency.String(),
+			writeTimeout.Received, writeTimeout.BlockFor, writeTimeout.WriteType, RetryableParam)
+	}
+	var unavailable *gocql.RequestErrUnavailable
+	if errors.As(err, &unavailable) {
+		return derrors.NewUnavailableError(msg, err).WithParams(unavailable.Consistency.String(),
+			unavailable.Required, unavailable.Alive, RetryableParam)
+	}
+	var alreadyExists *gocql.RequestErrAlreadyExists
+	if errors.As(err, &alreadyExists) {
+		return derrors.NewAlreadyExistsError(msg, err).WithParams(alreadyExists.Keyspace, alreadyExists.Table)
+	}
+	var readFailure *gocql.RequestErrReadFailure
+	if errors.As(err, &readFailure) {
+		return derrors.NewInternalError(msg, err).WithParams(readFailure.Consistency.String(),
+			readFailure.Received, readFailure.BlockFor, readFailure.NumFailures)
+	}
+	var writeFailure *gocql.RequestErrWriteFailure
+	if errors.As(err, &writeFailure) {
+		return derrors.NewInternalError(msg, err).WithParams(writeFailure.Consistency.String(),
+			writeFailure.Received, writeFailure.BlockFor, writeFailure.NumFailures, writeFailure.WriteType)
+	}
+	var functionFailure *gocql.RequestErrFunctionFailure
+	if errors.As(err, &functionFailure) {
+		return derrors.NewInternalError(msg, err).WithParams(functionFailure.Keyspace, functionFailure.Function)
+	}
+
+	var requestErr gocql.RequestError
+	if errors.As(err, &requestErr) {
+		switch requestErr.Code() {
+		case cqlOverloaded:
+			return derrors.NewResourceExhaustedError(msg, err).WithParams(RetryableParam)
+		case cqlBootstrapping:
+			return derrors.NewUnavailableError(msg, err).WithParams(RetryableParam)
+		case cqlSyntaxError, cqlInvalid:
+			return derrors.NewInvalidArgumentError(msg, err)
+		case cqlUnauthorized:
+			return derrors.NewPermissionDeniedError(msg, err)
+		case cqlBadCredentials:
+			return derrors.NewUnauthenticatedError(msg, err)
+		case cqlConfigError:
+			return derrors.NewFailedPreconditionError(msg, err)
+		case cqlServerError, cqlProtocolError, cqlTruncateError:
+			return derrors.NewInternalError(msg, err)
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return derrors.NewDeadlineExceededError(msg, err).WithParams(RetryableParam)
+		}
+		return derrors.NewUnavailableError(msg, err).WithParams(RetryableParam)
+	}
+	return derrors.NewGenericError(msg, err)
+}
+
+// IsNotFound checks if an error returned by a query means that the row does not exist.
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if dErr, ok := err.(derrors.Error); ok {
+		return dErr.Type() == derrors.NotFound
+	}
+	return errors.Is(err, gocql.ErrNotFound)
+}
+
+// IsRetryable checks if an error translated by TranslateError may succeed if the operation is repeated.
+func IsRetryable(err derrors.Error) bool {
+	generic, ok := err.(*derrors.GenericError)
+	if !ok || generic == nil {
+		return false
+	}
+	for _, param := range generic.Parameters {
+		if param == retryableParam {
+			return true
+		}
+	}
+	return false
+}
+
+// withParams adds parameters to an error, if it supports them.
+func withParams(err derrors.Error, params ...interface{}) derrors.Error {
+	if generic, ok := err.(*derrors.GenericError); ok {
+		return generic.WithParams(params...)
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"net"
+	"time"
+)
+
+// testRequestError is a gocql.RequestError with a given code.
+type testRequestError struct {
+	code int
+}
+
+func (e testRequestError) Code() int       { return e.code }
+func (e testRequestError) Message() string { return "request error" }
+func (e testRequestError) Error() string   { return e.Message() }
+
+var _ = ginkgo.Describe("Error translation", func() {
+
+	ginkgo.It("should distinguish canceled contexts", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := TranslateError(ctx, errors.New("query failed"), "cannot get element")
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NewCanceledError("").Type()))
+	})
+	ginkgo.It("should distinguish expired contexts", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+		<-ctx.Done()
+		err := TranslateError(ctx, errors.New("query failed"), "cannot get element")
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NewDeadlineExceededError("").Type()))
+	})
+	ginkgo.It("should keep the rest of errors", func() {
+		err := TranslateError(context.Background(), errors.New("query failed"), "cannot get element")
+		gomega.Expect(err.Type()).ShouldNot(gomega.Equal(derrors.NewCanceledError("").Type()))
+		gomega.Expect(err.Type()).ShouldNot(gomega.Equal(derrors.NewDeadlineExceededError("").Type()))
+	})
+
+	ginkgo.It("should translate the gocql errors", func() {
+		ctx := context.Background()
+		timeout := &net.OpError{Op: "read", Err: timeoutError{}}
+		expected := []struct {
+			err       error
+			errorType derrors.ErrorType
+			retryable bool
+		}{
+			{gocql.ErrNotFound, derrors.NotFound, false},
+			{gocql.ErrTimeoutNoResponse, derrors.DeadlineExceeded, true},
+			{gocql.ErrNoConnections, derrors.Unavailable, true},
+			{gocql.ErrSessionClosed, derrors.Unavailable, false},
+			{&gocql.RequestErrReadTimeout{}, derrors.DeadlineExceeded, true},
+			{&gocql.RequestErrWriteTimeout{WriteType: "SIMPLE"}, derrors.DeadlineExceeded, true},
+			{&gocql.RequestErrWriteTimeout{WriteType: "CAS"}, derrors.Aborted, false},
+			{&gocql.RequestErrWriteTimeout{WriteType: "COUNTER"}, derrors.Aborted, false},
+			{&gocql.RequestErrUnavailable{}, derrors.Unavailable, true},
+			{&gocql.RequestErrAlreadyExists{}, derrors.AlreadyExists, false},
+			{&gocql.RequestErrWriteFailure{}, derrors.Internal, false},
+			{testRequestError{cqlOverloaded}, derrors.ResourceExhausted, true},
+			{testRequestError{cqlBootstrapping}, derrors.Unavailable, true},
+			{testRequestError{cqlSyntaxError}, derrors.InvalidArgument, false},
+			{testRequestError{cqlInvalid}, derrors.InvalidArgument, false},
+			{testRequestError{cqlUnauthorized}, derrors.PermissionDenied, false},
+			{testRequestError{cqlBadCredentials}, derrors.Unauthenticated, false},
+			{testRequestError{cqlConfigError}, derrors.FailedPrecondition, false},
+			{timeout, derrors.DeadlineExceeded, true},
+			{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, derrors.Unavailable, true},
+			{errors.New("query failed"), derrors.Generic, false},
+		}
+		for _, e := range expected {
+			err := TranslateError(ctx, e.err, "cannot get element")
+			gomega.Expect(err.Type()).Should(gomega.Equal(e.errorType), e.err.Error())
+			gomega.Expect(IsRetryable(err)).Should(gomega.Equal(e.retryable), e.err.Error())
+		}
+	})
+	ginkgo.It("should return nil if there is no error", func() {
+		gomega.Expect(TranslateError(context.Background(), nil, "cannot get element")).Should(gomega.BeNil())
+	})
+	ginkgo.It("should keep the retryability once serialized", func() {
+		err := TranslateError(context.Background(), gocql.ErrTimeoutNoResponse, "cannot get element")
+		serialized, jsonErr := json.Marshal(err)
+		gomega.Expect(jsonErr).To(gomega.Succeed())
+		deserialized, jsonErr := derrors.FromJSON(serialized)
+		gomega.Expect(jsonErr).To(gomega.Succeed())
+		gomega.Expect(IsRetryable(deserialized)).Should(gomega.BeTrue())
+	})
+	ginkgo.It("should detect the rows not found", func() {
+		gomega.Expect(IsNotFound(gocql.ErrNotFound)).Should(gomega.BeTrue())
+		gomega.Expect(IsNotFound(TranslateError(context.Background(), gocql.ErrNotFound, "cannot get element"))).Should(gomega.BeTrue())
+		gomega.Expect(IsNotFound(errors.New("query failed"))).Should(gomega.BeFalse())
+		gomega.Expect(IsNotFound(nil)).Should(gomega.BeFalse())
+	})
+})
+
+// timeoutError is a net.Error that times out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
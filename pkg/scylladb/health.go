@@ -0,0 +1,185 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is the timeout of the health check query if the context has no deadline.
+const DefaultHealthCheckTimeout = 2 * time.Second
+
+// The names of the serving status follow the gRPC health checking protocol.
+const (
+	// ServingStatus is the status of a healthy connection.
+	ServingStatus = "SERVING"
+	// NotServingStatus is the status of an unhealthy connection.
+	NotServingStatus = "NOT_SERVING"
+)
+
+// HostStatus contains the state of a host of the cluster as seen by the session.
+type HostStatus struct {
+	// Address is the address and port used to connect to the host.
+	Address    string
+	DataCenter string
+	Rack       string
+	Up         bool
+}
+
+// HealthStatus contains the result of a health check.
+type HealthStatus struct {
+	// Healthy is true if the cluster answered the health check query.
+	Healthy bool
+	// Latency is the time the health check query took.
+	Latency time.Duration
+	// ReleaseVersion is the version of the host that answered the query.
+	ReleaseVersion string
+	// Hosts contains the state of the hosts known by the session, sorted by address.
+	Hosts []HostStatus
+	// Error contains the cause of an unhealthy status.
+	Error derrors.Error
+}
+
+// ServingStatus returns the name of the status of the gRPC health checking protocol that corresponds to the health
+// status, e.g. to look it up in grpc_health_v1.HealthCheckResponse_ServingStatus_value.
+func (h *HealthStatus) ServingStatus() string {
+	if h.Healthy {
+		return ServingStatus
+	}
+	return NotServingStatus
+}
+
+// HealthCheck checks that the cluster answers queries by reading the system.local table. The check uses
+// DefaultHealthCheckTimeout unless the context has a deadline. It does not try to reconnect if the session is not
+// created.
+func (s *ScyllaDB) HealthCheck(ctx context.Context) *HealthStatus {
+	s.sessionMutex.RLock()
+	session := s.Session
+	hosts := s.hosts
+	s.sessionMutex.RUnlock()
+
+	status := &HealthStatus{Hosts: hosts.status()}
+	if session == nil {
+		status.Error = derrors.NewUnavailableError("session not created")
+		return status
+	}
+
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultHealthCheckTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := session.Query("SELECT release_version FROM system.local").WithContext(ctx).Scan(&status.ReleaseVersion)
+	status.Latency = time.Since(start)
+	if err != nil {
+		status.Error = TranslateError(ctx, err, "health check failed")
+		return status
+	}
+	status.Healthy = true
+	return status
+}
+
+// hostTracker is a host selection policy that records the state of the hosts notified by the session and delegates
+// on another policy.
+type hostTracker struct {
+	gocql.HostSelectionPolicy
+	sync.Mutex
+	hosts map[string]HostStatus
+}
+
+// newHostTracker creates a hostTracker over a policy. The gocql default policy is used if it is nil.
+func newHostTracker(policy gocql.HostSelectionPolicy) *hostTracker {
+	if policy == nil {
+		policy = gocql.RoundRobinHostPolicy()
+	}
+	return &hostTracker{HostSelectionPolicy: policy, hosts: make(map[string]HostStatus, 0)}
+}
+
+func (t *hostTracker) AddHost(host *gocql.HostInfo) {
+	t.setHost(host, true)
+	t.HostSelectionPolicy.AddHost(host)
+}
+
+// AddHosts adds several hosts at once, using the bulk operation of the policy if available.
+func (t *hostTracker) AddHosts(hosts []*gocql.HostInfo) {
+	for _, host := range hosts {
+		t.setHost(host, true)
+	}
+	if bulk, ok := t.HostSelectionPolicy.(interface{ AddHosts([]*gocql.HostInfo) }); ok {
+		bulk.AddHosts(hosts)
+		return
+	}
+	for _, host := range hosts {
+		t.HostSelectionPolicy.AddHost(host)
+	}
+}
+
+func (t *hostTracker) RemoveHost(host *gocql.HostInfo) {
+	t.Lock()
+	delete(t.hosts, hostAddress(host))
+	t.Unlock()
+	t.HostSelectionPolicy.RemoveHost(host)
+}
+
+func (t *hostTracker) HostUp(host *gocql.HostInfo) {
+	t.setHost(host, true)
+	t.HostSelectionPolicy.HostUp(host)
+}
+
+func (t *hostTracker) HostDown(host *gocql.HostInfo) {
+	t.setHost(host, false)
+	t.HostSelectionPolicy.HostDown(host)
+}
+
+// setHost records the state of a host.
+func (t *hostTracker) setHost(host *gocql.HostInfo, up bool) {
+	t.Lock()
+	defer t.Unlock()
+	address := hostAddress(host)
+	t.hosts[address] = HostStatus{Address: address, DataCenter: host.DataCenter(), Rack: host.Rack(), Up: up}
+}
+
+// status returns the state of the hosts sorted by address. It can be called over a nil tracker.
+func (t *hostTracker) status() []HostStatus {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	result := make([]HostStatus, 0, len(t.hosts))
+	for _, h := range t.hosts {
+		result = append(result, h)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Address < result[j].Address
+	})
+	return result
+}
+
+// hostAddress returns the address used to connect to a host.
+func hostAddress(host *gocql.HostInfo) string {
+	return net.JoinHostPort(host.ConnectAddress().String(), strconv.Itoa(host.Port()))
+}
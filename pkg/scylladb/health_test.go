@@ -0,0 +1,57 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"net"
+)
+
+var _ = ginkgo.Describe("Health check", func() {
+
+	ginkgo.It("should not be healthy without session", func() {
+		sp := &ScyllaDB{Address: "127.0.0.1", Port: 9042}
+		status := sp.HealthCheck(context.Background())
+		gomega.Expect(status.Healthy).Should(gomega.BeFalse())
+		gomega.Expect(status.ServingStatus()).Should(gomega.Equal(NotServingStatus))
+		gomega.Expect(status.Error).ShouldNot(gomega.BeNil())
+		gomega.Expect(status.Hosts).Should(gomega.BeEmpty())
+	})
+	ginkgo.It("should track the state of the hosts", func() {
+		tracker := newHostTracker(nil)
+		host1 := (&gocql.HostInfo{}).SetConnectAddress(net.ParseIP("10.0.0.2"))
+		host2 := (&gocql.HostInfo{}).SetConnectAddress(net.ParseIP("10.0.0.1"))
+		tracker.AddHosts([]*gocql.HostInfo{host1})
+		tracker.AddHost(host2)
+		tracker.HostDown(host1)
+
+		gomega.Expect(tracker.status()).Should(gomega.Equal([]HostStatus{
+			{Address: "10.0.0.1:0", Up: true},
+			{Address: "10.0.0.2:0", Up: false},
+		}))
+
+		tracker.HostUp(host1)
+		tracker.RemoveHost(host2)
+		gomega.Expect(tracker.status()).Should(gomega.Equal([]HostStatus{
+			{Address: "10.0.0.2:0", Up: true},
+		}))
+	})
+})
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/scylladb/go-reflectx"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// UnsafeList retrieves a page of elements from a table. If pkColumn is not empty, only the elements of the partition
+// identified by those values are returned; otherwise the whole table is listed. The elements are loaded into results,
+// that must be a pointer to a slice of the struct where the data will be unmarshalled. The first page is requested
+// with an empty pageToken, and the returned token is used to request the next one. A nil token is returned when
+// there are no more pages.
+func (s *ScyllaDB) UnsafeList(table string, pkColumn map[string]interface{}, tableColumnNames []string, pageSize int, pageToken []byte, results interface{}) ([]byte, derrors.Error) {
+	return s.list(gocqlx.DefaultMapper, table, pkColumn, tableColumnNames, pageSize, pageToken, results)
+}
+
+// list retrieves a page of elements, loading the columns into the fields of the results resolved by the mapper.
+func (s *ScyllaDB) list(mapper *reflectx.Mapper, table string, pkColumn map[string]interface{}, tableColumnNames []string, pageSize int, pageToken []byte, results interface{}) ([]byte, derrors.Error) {
+	if pageSize <= 0 {
+		return nil, derrors.NewInvalidArgumentError("page size must be greater than zero").WithParams(pageSize)
+	}
+	// check connection
+	session, err := s.getSession(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	sb := qb.Select(table).Columns(tableColumnNames...)
+	for p := range pkColumn {
+		sb = sb.Where(qb.Eq(p))
+	}
+	stmt, names := sb.ToCql()
+	q := gocqlx.Query(session.Query(stmt), names).BindMap(pkColumn)
+	defer q.Release()
+	if q.Err() != nil {
+		return nil, TranslateError(context.Background(), q.Err(), "cannot list elements")
+	}
+	q.PageSize(pageSize)
+	q.PageState(pageToken)
+
+	iter := gocqlx.Iter(q.Query)
+	iter.Mapper = mapper
+	if err := iter.Select(results); err != nil {
+		return nil, TranslateError(context.Background(), err, "cannot list elements")
+	}
+
+	nextToken := iter.PageState()
+	if len(nextToken) == 0 {
+		return nil, nil
+	}
+	return nextToken, nil
+}
@@ -0,0 +1,549 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"reflect"
+)
+
+// Lightweight transactions (LWT) perform the existence check and the write in a single round trip using
+// Paxos. They are slower than a plain write but the check and the write are atomic, so two concurrent
+// writers can never both succeed.
+
+// ConditionFailedMsg corresponds to the error message returned when the condition of a conditional write is not met.
+const ConditionFailedMsg = "condition failed"
+
+// VersionConflictMsg corresponds to the error message returned when a versioned update finds that another writer
+// has already modified the element.
+const VersionConflictMsg = "version conflict"
+
+// conditionParamPrefix is used to name the bind parameters of the IF clause so they do not collide with the
+// columns being updated.
+const conditionParamPrefix = "if_"
+
+// NewConditionFailedError creates the error returned when a conditional write is not applied because the row does
+// not match the expected values. The current values of the row, as returned by ScyllaDB, are attached as params
+// formatted as strings, as some values such as NaN cannot be encoded.
+func NewConditionFailedError(table string, current map[string]interface{}) derrors.Error {
+	values := make(map[string]string, len(current))
+	for column, value := range current {
+		values[column] = fmt.Sprint(value)
+	}
+	return derrors.NewFailedPreconditionError(ConditionFailedMsg).WithParams(table, values)
+}
+
+// newVersionParamPrefix is used to name the bind parameter of the new version in a versioned update.
+const newVersionParamPrefix = "new_"
+
+// NewVersionConflictError creates the error returned when a versioned update is not applied because the stored
+// version is not the expected one. The current version of the row is attached as param.
+func NewVersionConflictError(table string, expectedVersion int64, currentVersion interface{}) derrors.Error {
+	return derrors.NewAbortedError(VersionConflictMsg).WithParams(table, expectedVersion, currentVersion)
+}
+
+// ----------------------------------------------------------------
+// functions for when the PK is composite of one field
+// ----------------------------------------------------------------
+
+// UnsafeAddIfNotExists atomically adds a new element to a table identified by a single primary key. The element
+// is only inserted if no other element with the same primary key exists (INSERT ... IF NOT EXISTS).
+func (s *ScyllaDB) UnsafeAddIfNotExists(table string, pkColumn string, pkValue string, tableColumnNames []string, toAdd interface{}) derrors.Error {
+	return s.UnsafeAddIfNotExistsContext(context.Background(), table, pkColumn, pkValue, tableColumnNames, toAdd)
+}
+
+// UnsafeAddIfNotExistsContext is the context-aware version of UnsafeAddIfNotExists. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeAddIfNotExistsContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string, toAdd interface{}) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt, names := qb.Insert(table).Columns(tableColumnNames...).Unique().ToCql()
+	applied, _, err := s.executeCAS(ctx, session, AddOperation, stmt, "cannot add new element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindStruct(toAdd)
+	})
+	if err != nil {
+		log.Warn().Str("err", err.Error()).Msg("error adding the element")
+		return err
+	}
+	if !applied {
+		return derrors.NewAlreadyExistsError(pkValue)
+	}
+
+	return nil
+}
+
+// UnsafeUpdateIfExists atomically updates an element in a table identified by a single primary key. The
+// update is only applied if the element exists (UPDATE ... IF EXISTS).
+func (s *ScyllaDB) UnsafeUpdateIfExists(table string, pkColumn string, pkValue string, tableColumnNames []string, toUpdate interface{}) derrors.Error {
+	return s.UnsafeUpdateIfContext(context.Background(), table, pkColumn, pkValue, tableColumnNames, toUpdate, nil)
+}
+
+// UnsafeUpdateIfExistsContext is the context-aware version of UnsafeUpdateIfExists. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeUpdateIfExistsContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string, toUpdate interface{}) derrors.Error {
+	return s.UnsafeUpdateIfContext(ctx, table, pkColumn, pkValue, tableColumnNames, toUpdate, nil)
+}
+
+// UnsafeUpdateIf atomically updates an element in a table identified by a single primary key. The update
+// is only applied if the current values of the row match the conditions map (UPDATE ... IF col = ?). If
+// conditions is empty, the update is applied if the element exists.
+func (s *ScyllaDB) UnsafeUpdateIf(table string, pkColumn string, pkValue string, tableColumnNames []string, toUpdate interface{}, conditions map[string]interface{}) derrors.Error {
+	return s.UnsafeUpdateIfContext(context.Background(), table, pkColumn, pkValue, tableColumnNames, toUpdate, conditions)
+}
+
+// UnsafeUpdateIfContext is the context-aware version of UnsafeUpdateIf. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeUpdateIfContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string, toUpdate interface{}, conditions map[string]interface{}) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt, names, values := conditionalUpdate(table, []string{pkColumn}, tableColumnNames, conditions)
+	applied, current, err := s.executeCAS(ctx, session, UpdateOperation, stmt, "cannot update element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindStructMap(toUpdate, values)
+	})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		notFound, err := s.casNotFound(ctx, table, map[string]interface{}{pkColumn: pkValue}, current)
+		if err != nil {
+			return err
+		}
+		if notFound {
+			return derrors.NewNotFoundError(pkValue)
+		}
+		return NewConditionFailedError(table, current)
+	}
+
+	return nil
+}
+
+// UnsafeRemoveIfExists atomically removes an element from a table identified by a single primary key
+// (DELETE ... IF EXISTS).
+func (s *ScyllaDB) UnsafeRemoveIfExists(table string, pkColumn string, pkValue string) derrors.Error {
+	return s.UnsafeRemoveIfContext(context.Background(), table, pkColumn, pkValue, nil)
+}
+
+// UnsafeRemoveIfExistsContext is the context-aware version of UnsafeRemoveIfExists. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeRemoveIfExistsContext(ctx context.Context, table string, pkColumn string, pkValue string) derrors.Error {
+	return s.UnsafeRemoveIfContext(ctx, table, pkColumn, pkValue, nil)
+}
+
+// UnsafeRemoveIf atomically removes an element from a table identified by a single primary key. The element
+// is only removed if the current values of the row match the conditions map (DELETE ... IF col = ?). If
+// conditions is empty, the element is removed if it exists.
+func (s *ScyllaDB) UnsafeRemoveIf(table string, pkColumn string, pkValue string, conditions map[string]interface{}) derrors.Error {
+	return s.UnsafeRemoveIfContext(context.Background(), table, pkColumn, pkValue, conditions)
+}
+
+// UnsafeRemoveIfContext is the context-aware version of UnsafeRemoveIf. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeRemoveIfContext(ctx context.Context, table string, pkColumn string, pkValue string, conditions map[string]interface{}) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	pk := map[string]interface{}{pkColumn: pkValue}
+	stmt, names, values := conditionalRemove(table, pk, conditions)
+	applied, current, err := s.executeCAS(ctx, session, RemoveOperation, stmt, "cannot remove element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindMap(values)
+	})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		notFound, err := s.casNotFound(ctx, table, pk, current)
+		if err != nil {
+			return err
+		}
+		if notFound {
+			return derrors.NewNotFoundError(pkValue)
+		}
+		return NewConditionFailedError(table, current)
+	}
+
+	return nil
+}
+
+// UnsafeVersionedUpdate updates an element in a table identified by a single primary key using optimistic
+// concurrency control. The update is only applied if the versionColumn of the stored element is expectedVersion,
+// and the version is incremented in the same statement. The new version is returned.
+func (s *ScyllaDB) UnsafeVersionedUpdate(table string, pkColumn string, pkValue string, versionColumn string, expectedVersion int64, tableColumnNames []string, toUpdate interface{}) (int64, derrors.Error) {
+	return s.UnsafeVersionedUpdateContext(context.Background(), table, pkColumn, pkValue, versionColumn, expectedVersion, tableColumnNames, toUpdate)
+}
+
+// UnsafeVersionedUpdateContext is the context-aware version of UnsafeVersionedUpdate. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeVersionedUpdateContext(ctx context.Context, table string, pkColumn string, pkValue string, versionColumn string, expectedVersion int64, tableColumnNames []string, toUpdate interface{}) (int64, derrors.Error) {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, names, values := versionedUpdate(table, []string{pkColumn}, versionColumn, expectedVersion, tableColumnNames)
+	applied, current, err := s.executeCAS(ctx, session, UpdateOperation, stmt, "cannot update element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindStructMap(toUpdate, values)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !applied {
+		notFound, err := s.casNotFound(ctx, table, map[string]interface{}{pkColumn: pkValue}, current)
+		if err != nil {
+			return 0, err
+		}
+		if notFound {
+			return 0, derrors.NewNotFoundError(pkValue)
+		}
+		return 0, NewVersionConflictError(table, expectedVersion, current[versionColumn])
+	}
+
+	return expectedVersion + 1, nil
+}
+
+// ----------------------------------------------------------------
+// functions for when the PK is composite of more than one field
+// ----------------------------------------------------------------
+
+// UnsafeCompositeAddIfNotExists atomically adds a new element to a table identified by a composite primary key. The
+// element is only inserted if no other element with the same primary key exists (INSERT ... IF NOT EXISTS).
+func (s *ScyllaDB) UnsafeCompositeAddIfNotExists(table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}) derrors.Error {
+	return s.UnsafeCompositeAddIfNotExistsContext(context.Background(), table, pkColumn, tableColumnNames, toAdd)
+}
+
+// UnsafeCompositeAddIfNotExistsContext is the context-aware version of UnsafeCompositeAddIfNotExists. The context is
+// passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeAddIfNotExistsContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt, names := qb.Insert(table).Columns(tableColumnNames...).Unique().ToCql()
+	applied, _, err := s.executeCAS(ctx, session, AddOperation, stmt, "cannot add new element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindStruct(toAdd)
+	})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return derrors.NewAlreadyExistsError(table).WithParams(getParams(pkColumn))
+	}
+
+	return nil
+}
+
+// UnsafeCompositeUpdateIfExists atomically updates an element in a table identified by a composite primary key.
+// The update is only applied if the element exists (UPDATE ... IF EXISTS).
+func (s *ScyllaDB) UnsafeCompositeUpdateIfExists(table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}) derrors.Error {
+	return s.UnsafeCompositeUpdateIfContext(context.Background(), table, pkColumn, tableColumnNames, toUpdate, nil)
+}
+
+// UnsafeCompositeUpdateIfExistsContext is the context-aware version of UnsafeCompositeUpdateIfExists. The context is
+// passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeUpdateIfExistsContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}) derrors.Error {
+	return s.UnsafeCompositeUpdateIfContext(ctx, table, pkColumn, tableColumnNames, toUpdate, nil)
+}
+
+// UnsafeCompositeUpdateIf atomically updates an element in a table identified by a composite primary key. The
+// update is only applied if the current values of the row match the conditions map (UPDATE ... IF col = ?). If
+// conditions is empty, the update is applied if the element exists.
+func (s *ScyllaDB) UnsafeCompositeUpdateIf(table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}, conditions map[string]interface{}) derrors.Error {
+	return s.UnsafeCompositeUpdateIfContext(context.Background(), table, pkColumn, tableColumnNames, toUpdate, conditions)
+}
+
+// UnsafeCompositeUpdateIfContext is the context-aware version of UnsafeCompositeUpdateIf. The context is passed to
+// all the queries.
+func (s *ScyllaDB) UnsafeCompositeUpdateIfContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}, conditions map[string]interface{}) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	pkNames := make([]string, 0, len(pkColumn))
+	for p := range pkColumn {
+		pkNames = append(pkNames, p)
+	}
+
+	stmt, names, values := conditionalUpdate(table, pkNames, tableColumnNames, conditions)
+	applied, current, err := s.executeCAS(ctx, session, UpdateOperation, stmt, "cannot update element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindStructMap(toUpdate, values)
+	})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		notFound, err := s.casNotFound(ctx, table, pkColumn, current)
+		if err != nil {
+			return err
+		}
+		if notFound {
+			return derrors.NewNotFoundError(table).WithParams(getParams(pkColumn))
+		}
+		return NewConditionFailedError(table, current)
+	}
+
+	return nil
+}
+
+// UnsafeCompositeRemoveIfExists atomically removes an element from a table identified by a composite primary key
+// (DELETE ... IF EXISTS).
+func (s *ScyllaDB) UnsafeCompositeRemoveIfExists(table string, pkColumn map[string]interface{}) derrors.Error {
+	return s.UnsafeCompositeRemoveIfContext(context.Background(), table, pkColumn, nil)
+}
+
+// UnsafeCompositeRemoveIfExistsContext is the context-aware version of UnsafeCompositeRemoveIfExists. The context is
+// passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeRemoveIfExistsContext(ctx context.Context, table string, pkColumn map[string]interface{}) derrors.Error {
+	return s.UnsafeCompositeRemoveIfContext(ctx, table, pkColumn, nil)
+}
+
+// UnsafeCompositeRemoveIf atomically removes an element from a table identified by a composite primary key. The
+// element is only removed if the current values of the row match the conditions map (DELETE ... IF col = ?). If
+// conditions is empty, the element is removed if it exists.
+func (s *ScyllaDB) UnsafeCompositeRemoveIf(table string, pkColumn map[string]interface{}, conditions map[string]interface{}) derrors.Error {
+	return s.UnsafeCompositeRemoveIfContext(context.Background(), table, pkColumn, conditions)
+}
+
+// UnsafeCompositeRemoveIfContext is the context-aware version of UnsafeCompositeRemoveIf. The context is passed to
+// all the queries.
+func (s *ScyllaDB) UnsafeCompositeRemoveIfContext(ctx context.Context, table string, pkColumn map[string]interface{}, conditions map[string]interface{}) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt, names, values := conditionalRemove(table, pkColumn, conditions)
+	applied, current, err := s.executeCAS(ctx, session, RemoveOperation, stmt, "cannot remove element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindMap(values)
+	})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		notFound, err := s.casNotFound(ctx, table, pkColumn, current)
+		if err != nil {
+			return err
+		}
+		if notFound {
+			return derrors.NewNotFoundError(table).WithParams(getParams(pkColumn))
+		}
+		return NewConditionFailedError(table, current)
+	}
+
+	return nil
+}
+
+// UnsafeCompositeVersionedUpdate updates an element in a table identified by a composite primary key using
+// optimistic concurrency control. The update is only applied if the versionColumn of the stored element is
+// expectedVersion, and the version is incremented in the same statement. The new version is returned.
+func (s *ScyllaDB) UnsafeCompositeVersionedUpdate(table string, pkColumn map[string]interface{}, versionColumn string, expectedVersion int64, tableColumnNames []string, toUpdate interface{}) (int64, derrors.Error) {
+	return s.UnsafeCompositeVersionedUpdateContext(context.Background(), table, pkColumn, versionColumn, expectedVersion, tableColumnNames, toUpdate)
+}
+
+// UnsafeCompositeVersionedUpdateContext is the context-aware version of UnsafeCompositeVersionedUpdate. The context
+// is passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeVersionedUpdateContext(ctx context.Context, table string, pkColumn map[string]interface{}, versionColumn string, expectedVersion int64, tableColumnNames []string, toUpdate interface{}) (int64, derrors.Error) {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pkNames := make([]string, 0, len(pkColumn))
+	for p := range pkColumn {
+		pkNames = append(pkNames, p)
+	}
+
+	stmt, names, values := versionedUpdate(table, pkNames, versionColumn, expectedVersion, tableColumnNames)
+	applied, current, err := s.executeCAS(ctx, session, UpdateOperation, stmt, "cannot update element", func(q *gocql.Query) *gocqlx.Queryx {
+		return gocqlx.Query(q, names).BindStructMap(toUpdate, values)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !applied {
+		notFound, err := s.casNotFound(ctx, table, pkColumn, current)
+		if err != nil {
+			return 0, err
+		}
+		if notFound {
+			return 0, derrors.NewNotFoundError(table).WithParams(getParams(pkColumn))
+		}
+		return 0, NewVersionConflictError(table, expectedVersion, current[versionColumn])
+	}
+
+	return expectedVersion + 1, nil
+}
+
+// versionedUpdate builds the statement that updates the tableColumnNames of the row identified by pkNames and sets
+// versionColumn to expectedVersion + 1 if the stored version is expectedVersion. The values of the versions are
+// returned to be bound with the struct.
+func versionedUpdate(table string, pkNames []string, versionColumn string, expectedVersion int64, tableColumnNames []string) (string, []string, qb.M) {
+	// the version is always set from the expected one, never from the struct
+	columns := make([]string, 0, len(tableColumnNames))
+	for _, c := range tableColumnNames {
+		if c != versionColumn {
+			columns = append(columns, c)
+		}
+	}
+
+	newVersionName := newVersionParamPrefix + versionColumn
+	expectedVersionName := conditionParamPrefix + versionColumn
+
+	sb := qb.Update(table).Set(columns...).SetNamed(versionColumn, newVersionName)
+	for _, p := range pkNames {
+		sb = sb.Where(qb.Eq(p))
+	}
+	sb = sb.If(qb.EqNamed(versionColumn, expectedVersionName))
+
+	stmt, names := sb.ToCql()
+	return stmt, names, qb.M{
+		newVersionName:      expectedVersion + 1,
+		expectedVersionName: expectedVersion,
+	}
+}
+
+// conditionalUpdate builds the statement that updates the tableColumnNames of the row identified by pkNames if the
+// conditions are met. The update is conditioned to the existence of the row if there are no conditions. The values
+// of the conditions are returned to be bound with the struct.
+func conditionalUpdate(table string, pkNames []string, tableColumnNames []string, conditions map[string]interface{}) (string, []string, qb.M) {
+	sb := qb.Update(table).Set(tableColumnNames...)
+	for _, p := range pkNames {
+		sb = sb.Where(qb.Eq(p))
+	}
+	cmps, values := ifConditions(conditions)
+	if len(cmps) == 0 {
+		sb = sb.Existing()
+	} else {
+		sb = sb.If(cmps...)
+	}
+
+	stmt, names := sb.ToCql()
+	return stmt, names, values
+}
+
+// conditionalRemove builds the statement that removes the row identified by pkColumn if the conditions are met. The
+// removal is conditioned to the existence of the row if there are no conditions. The values of the key and the
+// conditions are returned to be bound.
+func conditionalRemove(table string, pkColumn map[string]interface{}, conditions map[string]interface{}) (string, []string, qb.M) {
+	sb := qb.Delete(table)
+	for p := range pkColumn {
+		sb = sb.Where(qb.Eq(p))
+	}
+	cmps, values := ifConditions(conditions)
+	if len(cmps) == 0 {
+		sb = sb.Existing()
+	} else {
+		sb = sb.If(cmps...)
+	}
+	for p, v := range pkColumn {
+		values[p] = v
+	}
+
+	stmt, names := sb.ToCql()
+	return stmt, names, values
+}
+
+// ifConditions builds the comparisons of an IF clause and the map with the values to be bound.
+func ifConditions(conditions map[string]interface{}) ([]qb.Cmp, qb.M) {
+	cmps := make([]qb.Cmp, 0, len(conditions))
+	values := make(qb.M, len(conditions))
+	for column, value := range conditions {
+		name := conditionParamPrefix + column
+		cmps = append(cmps, qb.EqNamed(column, name))
+		values[name] = value
+	}
+	return cmps, values
+}
+
+// casNotFound checks if a conditional write was not applied because the row does not exist. ScyllaDB only returns
+// the [applied] column for IF EXISTS on a missing row, but for IF col = ? it also returns the columns of the
+// conditions set to null, that gocql scans as zero values. As a stored row may also contain those values, the
+// existence of the row is checked when all the values are empty.
+func (s *ScyllaDB) casNotFound(ctx context.Context, table string, pkColumn map[string]interface{}, current map[string]interface{}) (bool, derrors.Error) {
+	if len(current) == 0 {
+		return true, nil
+	}
+	for _, value := range current {
+		if !isEmptyValue(value) {
+			return false, nil
+		}
+	}
+	exists, err := s.UnsafeGenericCompositeExistContext(ctx, table, pkColumn)
+	if err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// isEmptyValue checks if a value scanned by gocql may correspond to a null column.
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// executeCAS executes a conditional statement following the retry policy of the operation, binding its parameters
+// with bind. As conditional statements are not idempotent, they are executed once.
+func (s *ScyllaDB) executeCAS(ctx context.Context, session *gocql.Session, operation Operation, stmt string, msg string, bind func(q *gocql.Query) *gocqlx.Queryx) (bool, map[string]interface{}, derrors.Error) {
+	var applied bool
+	var current map[string]interface{}
+	err := s.execute(ctx, session, operation, stmt, msg, func(q *gocql.Query) error {
+		var cqlErr error
+		applied, current, cqlErr = execCAS(bind(q))
+		return cqlErr
+	})
+	return applied, current, err
+}
+
+// execCAS executes a conditional statement and returns whether it was applied. If it was not, the current values
+// of the row are returned. The query is released afterwards.
+func execCAS(q *gocqlx.Queryx) (bool, map[string]interface{}, error) {
+	defer q.Release()
+	if q.Err() != nil {
+		return false, nil, q.Err()
+	}
+	current := make(map[string]interface{})
+	applied, err := q.MapScanCAS(current)
+	if err != nil {
+		return false, nil, err
+	}
+	delete(current, "[applied]")
+	return applied, current, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/scylladb/gocqlx/qb"
+	"math"
+)
+
+var _ = ginkgo.Describe("Lightweight transactions", func() {
+
+	ginkgo.It("should attach the current values that cannot be encoded", func() {
+		err := NewConditionFailedError(Table, map[string]interface{}{"ratio": math.NaN(), "id3": "value"})
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.FailedPrecondition))
+		gomega.Expect(err.(*derrors.GenericError).Parameters).Should(gomega.ContainElement(`{"id3":"value","ratio":"NaN"}`))
+	})
+	ginkgo.It("should detect the values of null columns", func() {
+		for _, value := range []interface{}{nil, "", int64(0), []string{}, map[string]string{}} {
+			gomega.Expect(isEmptyValue(value)).Should(gomega.BeTrue())
+		}
+		for _, value := range []interface{}{"value", int64(1), []string{"value"}, true} {
+			gomega.Expect(isEmptyValue(value)).Should(gomega.BeFalse())
+		}
+	})
+	ginkgo.It("should build conditional updates on the existence of the element", func() {
+		stmt, names, values := conditionalUpdate(Table, []string{"id1"}, []string{"id2"}, nil)
+		gomega.Expect(stmt).Should(gomega.Equal("UPDATE " + Table + " SET id2=? WHERE id1=? IF EXISTS "))
+		gomega.Expect(names).Should(gomega.Equal([]string{"id2", "id1"}))
+		gomega.Expect(values).Should(gomega.BeEmpty())
+	})
+	ginkgo.It("should bind the key and the conditions of conditional removals", func() {
+		stmt, names, values := conditionalRemove(Table, map[string]interface{}{"id1": "key"}, map[string]interface{}{"id2": "value"})
+		gomega.Expect(stmt).Should(gomega.Equal("DELETE FROM " + Table + " WHERE id1=? IF id2=? "))
+		gomega.Expect(names).Should(gomega.Equal([]string{"id1", "if_id2"}))
+		gomega.Expect(values).Should(gomega.Equal(qb.M{"id1": "key", "if_id2": "value"}))
+	})
+	ginkgo.It("should bind the expected and the new version of versioned updates", func() {
+		stmt, names, values := versionedUpdate(Table, []string{"id1"}, "version", 3, []string{"id2", "version"})
+		gomega.Expect(stmt).Should(gomega.Equal("UPDATE " + Table + " SET id2=?,version=? WHERE id1=? IF version=? "))
+		gomega.Expect(names).Should(gomega.Equal([]string{"id2", "new_version", "id1", "if_version"}))
+		gomega.Expect(values).Should(gomega.Equal(qb.M{"new_version": int64(4), "if_version": int64(3)}))
+	})
+})
@@ -0,0 +1,346 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/scylladb/go-reflectx"
+	"reflect"
+	"strings"
+)
+
+// The metadata of a table is derived from the struct used to store its rows. The name of a column is taken from the
+// `db` tag, the `cql` tag or the name of the field in snake case, in that order, and fields tagged with "-" are
+// ignored. The primary key is declared with the pk (partition key) and ck (clustering key) tag options, following
+// the order of the fields:
+//
+//	type Registry struct {
+//	    OrganizationId string `cql:"organization_id,pk"`
+//	    RegistryId     string `cql:"registry_id,ck"`
+//	    Name           string `cql:"name"`
+//	}
+
+// PartitionKeyTagOption marks the fields that belong to the partition key.
+const PartitionKeyTagOption = "pk"
+
+// ClusteringKeyTagOption marks the fields that belong to the clustering key.
+const ClusteringKeyTagOption = "ck"
+
+// TableMetadata contains the columns of a table and the struct fields where they are stored.
+type TableMetadata struct {
+	// Table is the name of the table.
+	Table string
+	// PartitionKey contains the columns of the partition key.
+	PartitionKey []string
+	// ClusteringKey contains the columns of the clustering key.
+	ClusteringKey []string
+	// Columns contains all the columns of the table.
+	Columns []string
+	// entityType is the struct type the metadata was built from.
+	entityType reflect.Type
+	// fields contains the struct field that corresponds to each column.
+	fields map[string]reflect.StructField
+	// fieldColumns contains the column of each struct field, or "-" if the field is ignored.
+	fieldColumns map[string]string
+	// mapper binds and scans the struct fields with the same column names as the metadata.
+	mapper *reflectx.Mapper
+}
+
+// NewTableMetadata builds the metadata of a table from a struct, taking the primary key from the tag options.
+func NewTableMetadata(table string, entity interface{}) (*TableMetadata, derrors.Error) {
+	return newTableMetadata(table, entity, nil, nil)
+}
+
+// NewTableMetadataWithKeys builds the metadata of a table from a struct, using the given primary key columns
+// instead of the tag options.
+func NewTableMetadataWithKeys(table string, entity interface{}, partitionKey []string, clusteringKey []string) (*TableMetadata, derrors.Error) {
+	if len(partitionKey) == 0 {
+		return nil, derrors.NewInvalidArgumentError("partition key cannot be empty").WithParams(table)
+	}
+	return newTableMetadata(table, entity, partitionKey, clusteringKey)
+}
+
+func newTableMetadata(table string, entity interface{}, partitionKey []string, clusteringKey []string) (*TableMetadata, derrors.Error) {
+	entityType := reflect.TypeOf(entity)
+	for entityType != nil && entityType.Kind() == reflect.Ptr {
+		entityType = entityType.Elem()
+	}
+	if entityType == nil || entityType.Kind() != reflect.Struct {
+		return nil, derrors.NewInvalidArgumentError("table entities must be structs").WithParams(table)
+	}
+
+	md := &TableMetadata{
+		Table:        table,
+		entityType:   entityType,
+		fields:       make(map[string]reflect.StructField, 0),
+		fieldColumns: make(map[string]string, 0),
+	}
+	taggedPartitionKey, taggedClusteringKey := md.addColumns(entityType, nil)
+	// gocqlx.DefaultMapper only reads the db tag, so the fields are mapped by name to the columns found above
+	md.mapper = reflectx.NewMapperFunc("", md.fieldColumn)
+
+	if partitionKey == nil {
+		partitionKey, clusteringKey = taggedPartitionKey, taggedClusteringKey
+	}
+	if len(partitionKey) == 0 {
+		return nil, derrors.NewInvalidArgumentError("partition key not defined").WithParams(table, entityType.String())
+	}
+	for _, k := range append(append([]string{}, partitionKey...), clusteringKey...) {
+		if _, exists := md.fields[k]; !exists {
+			return nil, derrors.NewInvalidArgumentError("key column not found in entity").WithParams(table, k)
+		}
+	}
+	md.PartitionKey = partitionKey
+	md.ClusteringKey = clusteringKey
+
+	return md, nil
+}
+
+// addColumns adds the columns of a struct type and returns the columns tagged as partition and clustering key.
+func (md *TableMetadata) addColumns(structType reflect.Type, index []int) ([]string, []string) {
+	partitionKey := make([]string, 0)
+	clusteringKey := make([]string, 0)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		field.Index = append(append([]int{}, index...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			pk, ck := md.addColumns(field.Type, field.Index)
+			partitionKey = append(partitionKey, pk...)
+			clusteringKey = append(clusteringKey, ck...)
+			continue
+		}
+		if field.PkgPath != "" {
+			// unexported field
+			continue
+		}
+		name, options := parseColumnTag(field)
+		md.fieldColumns[field.Name] = name
+		if name == "-" {
+			continue
+		}
+		md.fields[name] = field
+		md.Columns = append(md.Columns, name)
+		if options[PartitionKeyTagOption] {
+			partitionKey = append(partitionKey, name)
+		} else if options[ClusteringKeyTagOption] {
+			clusteringKey = append(clusteringKey, name)
+		}
+	}
+	return partitionKey, clusteringKey
+}
+
+// parseColumnTag returns the name of the column of a struct field and its tag options.
+func parseColumnTag(field reflect.StructField) (string, map[string]bool) {
+	for _, tag := range []string{"db", "cql"} {
+		value, exists := field.Tag.Lookup(tag)
+		if !exists {
+			continue
+		}
+		parts := strings.Split(value, ",")
+		options := make(map[string]bool, len(parts)-1)
+		for _, o := range parts[1:] {
+			options[strings.TrimSpace(o)] = true
+		}
+		name := parts[0]
+		if name == "" {
+			name = reflectx.CamelToSnakeASCII(field.Name)
+		}
+		return name, options
+	}
+	return reflectx.CamelToSnakeASCII(field.Name), map[string]bool{}
+}
+
+// fieldColumn returns the column of a struct field. The fields that are not columns, such as the fields of nested
+// structs, are mapped to their name in snake case.
+func (md *TableMetadata) fieldColumn(fieldName string) string {
+	if column, exists := md.fieldColumns[fieldName]; exists {
+		return column
+	}
+	return reflectx.CamelToSnakeASCII(fieldName)
+}
+
+// KeyColumns returns the columns of the primary key.
+func (md *TableMetadata) KeyColumns() []string {
+	return append(append([]string{}, md.PartitionKey...), md.ClusteringKey...)
+}
+
+// NonKeyColumns returns the columns that do not belong to the primary key.
+func (md *TableMetadata) NonKeyColumns() []string {
+	keyColumns := make(map[string]bool, len(md.PartitionKey)+len(md.ClusteringKey))
+	for _, k := range md.KeyColumns() {
+		keyColumns[k] = true
+	}
+	columns := make([]string, 0, len(md.Columns))
+	for _, c := range md.Columns {
+		if !keyColumns[c] {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// IsKeyColumn checks if a column belongs to the primary key.
+func (md *TableMetadata) IsKeyColumn(column string) bool {
+	for _, k := range md.KeyColumns() {
+		if k == column {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskColumns returns the columns that correspond to the paths of a field mask, such as the paths of a protobuf
+// FieldMask. A path is the name of a column, the name of a struct field or the name of a struct field in snake case.
+// The paths must not refer to the primary key, and nested paths are not supported because the columns that store
+// structs are written as a whole.
+func (md *TableMetadata) MaskColumns(paths []string) ([]string, derrors.Error) {
+	if len(paths) == 0 {
+		return nil, derrors.NewInvalidArgumentError("field mask cannot be empty").WithParams(md.Table)
+	}
+	columns := make([]string, 0, len(paths))
+	added := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if strings.Contains(path, ".") {
+			return nil, derrors.NewInvalidArgumentError("nested field mask paths are not supported").WithParams(md.Table, path)
+		}
+		column, found := md.pathColumn(path)
+		if !found {
+			return nil, derrors.NewInvalidArgumentError("field mask path not found in entity").WithParams(md.Table, path)
+		}
+		if md.IsKeyColumn(column) {
+			return nil, derrors.NewInvalidArgumentError("primary key columns cannot be updated").WithParams(md.Table, path)
+		}
+		if !added[column] {
+			added[column] = true
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+// pathColumn returns the column that corresponds to a path of a field mask.
+func (md *TableMetadata) pathColumn(path string) (string, bool) {
+	if _, exists := md.fields[path]; exists {
+		return path, true
+	}
+	for _, column := range md.Columns {
+		name := md.fields[column].Name
+		if path == name || path == reflectx.CamelToSnakeASCII(name) {
+			return column, true
+		}
+	}
+	return "", false
+}
+
+// KeyValues returns the values of the primary key of an entity indexed by the column name.
+func (md *TableMetadata) KeyValues(entity interface{}) (map[string]interface{}, derrors.Error) {
+	value, err := md.entityValue(entity)
+	if err != nil {
+		return nil, err
+	}
+	key := make(map[string]interface{}, len(md.PartitionKey)+len(md.ClusteringKey))
+	for _, k := range md.KeyColumns() {
+		key[k] = value.FieldByIndex(md.fields[k].Index).Interface()
+	}
+	return key, nil
+}
+
+// entityValue returns the struct value of an entity, checking that it has the type of the metadata.
+func (md *TableMetadata) entityValue(entity interface{}) (reflect.Value, derrors.Error) {
+	value := reflect.ValueOf(entity)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if !value.IsValid() || value.Type() != md.entityType {
+		return reflect.Value{}, derrors.NewInvalidArgumentError("entity does not match table metadata").WithParams(md.Table, reflect.TypeOf(entity))
+	}
+	return value, nil
+}
+
+// ----------------------------------------------------------------
+// functions driven by the table metadata
+// ----------------------------------------------------------------
+
+// UnsafeEntityExist checks if the element with the primary key of an entity exists.
+func (s *ScyllaDB) UnsafeEntityExist(md *TableMetadata, entity interface{}) (bool, derrors.Error) {
+	key, err := md.KeyValues(entity)
+	if err != nil {
+		return false, err
+	}
+	return s.UnsafeGenericCompositeExist(md.Table, key)
+}
+
+// UnsafeEntityAdd adds a new element with all the columns of an entity.
+func (s *ScyllaDB) UnsafeEntityAdd(md *TableMetadata, toAdd interface{}, options ...WriteOption) derrors.Error {
+	key, err := md.KeyValues(toAdd)
+	if err != nil {
+		return err
+	}
+	return s.compositeAdd(context.Background(), md.mapper, md.Table, key, md.Columns, toAdd, options)
+}
+
+// UnsafeEntityUpdate updates all the columns of an entity that do not belong to the primary key.
+func (s *ScyllaDB) UnsafeEntityUpdate(md *TableMetadata, toUpdate interface{}, options ...WriteOption) derrors.Error {
+	key, err := md.KeyValues(toUpdate)
+	if err != nil {
+		return err
+	}
+	return s.compositeUpdate(context.Background(), md.mapper, md.Table, key, md.NonKeyColumns(), toUpdate, options)
+}
+
+// UnsafeEntityUpdateFields updates the columns of an entity that correspond to the paths of a field mask. See
+// TableMetadata.MaskColumns for the format of the paths.
+func (s *ScyllaDB) UnsafeEntityUpdateFields(md *TableMetadata, toUpdate interface{}, paths []string, options ...WriteOption) derrors.Error {
+	columns, err := md.MaskColumns(paths)
+	if err != nil {
+		return err
+	}
+	key, err := md.KeyValues(toUpdate)
+	if err != nil {
+		return err
+	}
+	return s.compositeUpdate(context.Background(), md.mapper, md.Table, key, columns, toUpdate, options)
+}
+
+// UnsafeEntityUpsert inserts or overwrites all the columns of an entity, whether it exists or not.
+func (s *ScyllaDB) UnsafeEntityUpsert(md *TableMetadata, toUpsert interface{}, options ...WriteOption) derrors.Error {
+	key, err := md.KeyValues(toUpsert)
+	if err != nil {
+		return err
+	}
+	return s.compositeUpsert(context.Background(), md.mapper, md.Table, key, md.Columns, toUpsert, options)
+}
+
+// UnsafeEntityGet retrieves the element with the primary key of an entity, loading all its columns into the entity.
+func (s *ScyllaDB) UnsafeEntityGet(md *TableMetadata, result interface{}) derrors.Error {
+	key, err := md.KeyValues(result)
+	if err != nil {
+		return err
+	}
+	return s.compositeGet(context.Background(), md.mapper, md.Table, key, md.Columns, result)
+}
+
+// UnsafeEntityRemove removes the element with the primary key of an entity.
+func (s *ScyllaDB) UnsafeEntityRemove(md *TableMetadata, toRemove interface{}) derrors.Error {
+	key, err := md.KeyValues(toRemove)
+	if err != nil {
+		return err
+	}
+	return s.UnsafeCompositeRemove(md.Table, key)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"reflect"
+)
+
+type TaggedStruct struct {
+	Id1      string `cql:"id1,pk"`
+	Id2      string `cql:"id2,ck"`
+	Id3      string `cql:"id3"`
+	Ignored  string `cql:"-"`
+	internal string
+}
+
+type RenamedStruct struct {
+	Key   string `cql:"id1,pk"`
+	Id2   string `db:"id2,ck"`
+	Label string `cql:"id3"`
+}
+
+// MaskedTable is created by the integration tests from MaskedStruct.
+const MaskedTable = "maskedtabletest"
+
+type PartialStruct struct {
+	Id1 string `cql:"id1,pk"`
+	Id2 string `cql:"id2,ck"`
+	Id3 string `cql:"-"`
+}
+
+type MaskedStruct struct {
+	Id1         string `cql:"id1,pk"`
+	DisplayName string `cql:"name"`
+	Id3         string
+}
+
+var _ = ginkgo.Describe("Table metadata", func() {
+
+	ginkgo.It("should take the primary key from the tag options", func() {
+		md, err := NewTableMetadata(Table, &TaggedStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(md.Columns).Should(gomega.Equal(AllTableColumns))
+		gomega.Expect(md.PartitionKey).Should(gomega.Equal([]string{"id1"}))
+		gomega.Expect(md.ClusteringKey).Should(gomega.Equal([]string{"id2"}))
+		gomega.Expect(md.NonKeyColumns()).Should(gomega.Equal(AllCompositeTableColumnsNoPK))
+
+		key, err := md.KeyValues(&TaggedStruct{Id1: "a", Id2: "b", Id3: "c"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(key).Should(gomega.Equal(map[string]interface{}{"id1": "a", "id2": "b"}))
+	})
+	ginkgo.It("should use the given primary key", func() {
+		md, err := NewTableMetadataWithKeys(BasicTable, CompositeStruct{}, []string{"id1"}, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(md.NonKeyColumns()).Should(gomega.Equal(AllTableColumnsNoPK))
+	})
+	ginkgo.It("should fail if the primary key is not defined", func() {
+		_, err := NewTableMetadata(Table, &CompositeStruct{})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		_, err = NewTableMetadataWithKeys(Table, &CompositeStruct{}, []string{"id4"}, nil)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+	ginkgo.It("should not accept entities of another type", func() {
+		md, err := NewTableMetadata(Table, &TaggedStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+
+		_, err = md.KeyValues(&CompositeStruct{})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+	ginkgo.It("should bind the fields with the column names of the tags", func() {
+		md, err := NewTableMetadata(Table, &RenamedStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(md.Columns).Should(gomega.Equal(AllTableColumns))
+
+		entity := &RenamedStruct{Key: "a", Id2: "b", Label: "c"}
+		fields := md.mapper.FieldMap(reflect.ValueOf(entity).Elem())
+		gomega.Expect(fields).Should(gomega.HaveLen(3))
+		for _, column := range md.Columns {
+			gomega.Expect(fields).Should(gomega.HaveKey(column))
+		}
+		batch := (&ScyllaDB{}).NewBatch(gocql.LoggedBatch).EntityAdd(md, entity).EntityUpdate(md, entity)
+		gomega.Expect(batch.err).Should(gomega.BeNil())
+		gomega.Expect(batch.statements).Should(gomega.Equal([]batchStatement{
+			{stmt: "INSERT INTO tabletest (id1,id2,id3) VALUES (?,?,?) ", values: []interface{}{"a", "b", "c"}},
+			{stmt: "UPDATE tabletest SET id3=? WHERE id1=? AND id2=? ", values: []interface{}{"c", "a", "b"}},
+		}))
+	})
+	ginkgo.It("should map the paths of a field mask to columns", func() {
+		md, err := NewTableMetadata(Table, &MaskedStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+
+		columns, err := md.MaskColumns([]string{"display_name", "DisplayName", "name", "id3"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(columns).Should(gomega.Equal([]string{"name", "id3"}))
+		gomega.Expect(md.mapper.TraversalsByName(reflect.TypeOf(MaskedStruct{}), columns)).Should(gomega.Equal([][]int{{1}, {2}}))
+
+		for _, paths := range [][]string{nil, {"id1"}, {"missing"}, {"name.first"}} {
+			_, err = md.MaskColumns(paths)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.InvalidArgument))
+		}
+	})
+})
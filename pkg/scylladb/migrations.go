@@ -0,0 +1,303 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are CQL files named NNN_description.cql, applied in the order of their version NNN. Each file may
+// contain several statements separated by semicolons, and the runner waits for schema agreement after each of them.
+// The applied versions are recorded with the checksum of their file in the migrations table of the keyspace of the
+// session, so the keyspace must exist before connecting. A file cannot be modified once applied: the runner refuses
+// to apply any migration if the checksum of an applied file changed. If a migration fails, the statements applied
+// before the failure are not rolled back, so statements should use IF NOT EXISTS / IF EXISTS when possible.
+// Before applying a version, the runner claims it with a lightweight transaction, and it is marked as applied by
+// setting its applied_at when all its statements succeed. Only one of several processes running the migrations
+// concurrently applies each version; the others stop with an Aborted error when they find a version claimed and
+// not applied yet, and can retry later.
+
+// DefaultMigrationsTable is the name of the table where the applied migrations are recorded.
+const DefaultMigrationsTable = "schema_migrations"
+
+// ChecksumMismatchMsg is the message of the error returned when an applied migration has been modified.
+const ChecksumMismatchMsg = "checksum of applied migration changed"
+
+// MigrationInProgressMsg is the message of the error returned when a migration is being applied by another process.
+const MigrationInProgressMsg = "migration is being applied by another process"
+
+// migrationFileRegexp matches the names of the migration files.
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.cql$`)
+
+// Migration contains a schema migration loaded from a CQL file.
+type Migration struct {
+	// Version is the number the file name starts with.
+	Version int
+	// Description is the rest of the file name.
+	Description string
+	// Statements contains the CQL statements of the file.
+	Statements []string
+	// Checksum is the SHA-256 of the file contents.
+	Checksum string
+}
+
+// appliedMigration contains a migration recorded in the migrations table.
+type appliedMigration struct {
+	Version     int
+	Description string
+	Checksum    string
+	// AppliedAt is zero if the migration has been claimed but not applied yet.
+	AppliedAt time.Time
+}
+
+// MigrateOptions contains the parameters of the migration runner.
+type MigrateOptions struct {
+	// Table is the name of the migrations table. DefaultMigrationsTable is used if empty.
+	Table string
+}
+
+// LoadMigrations reads the migration files found in the root of a file system, sorted by version. Use os.DirFS to
+// read them from a directory, or fs.Sub to read them from a directory of an embed.FS. Other files are ignored.
+func LoadMigrations(fsys fs.FS) ([]Migration, derrors.Error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read migrations")
+	}
+	migrations := make([]Migration, 0)
+	versions := make(map[int]string, 0)
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		if previous, exists := versions[version]; exists {
+			return nil, derrors.NewInvalidArgumentError("duplicated migration version").WithParams(previous, entry.Name())
+		}
+		versions[version] = entry.Name()
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, derrors.NewGenericError("cannot read migration", err).WithParams(entry.Name())
+		}
+		checksum := sha256.Sum256(content)
+		migrations = append(migrations, Migration{
+			Version:     version,
+			Description: match[2],
+			Statements:  SplitStatements(string(content)),
+			Checksum:    hex.EncodeToString(checksum[:]),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// SplitStatements splits a CQL script into its statements. Semicolons inside strings, quoted identifiers and
+// comments are not considered separators, and the comments are removed.
+func SplitStatements(script string) []string {
+	statements := make([]string, 0)
+	var current strings.Builder
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"':
+			// strings and quoted identifiers escape the quote by doubling it, so the closing quote is the first
+			// one found after the opening
+			end := strings.IndexByte(script[i+1:], c)
+			if end < 0 {
+				current.WriteString(script[i:])
+				i = len(script)
+				continue
+			}
+			current.WriteString(script[i : i+end+2])
+			i += end + 1
+		case strings.HasPrefix(script[i:], "$$"):
+			end := strings.Index(script[i+2:], "$$")
+			if end < 0 {
+				current.WriteString(script[i:])
+				i = len(script)
+				continue
+			}
+			current.WriteString(script[i : i+end+4])
+			i += end + 3
+		case strings.HasPrefix(script[i:], "--") || strings.HasPrefix(script[i:], "//"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+				continue
+			}
+			current.WriteByte('\n')
+			i += end
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+				continue
+			}
+			current.WriteByte(' ')
+			i += end + 3
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// Migrate applies the migrations that have not been applied yet, in order, and returns them. No migration is
+// applied if the checksum of an applied one changed.
+func (s *ScyllaDB) Migrate(ctx context.Context, migrations []Migration, options MigrateOptions) ([]Migration, derrors.Error) {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	table := options.Table
+	if table == "" {
+		table = DefaultMigrationsTable
+	}
+
+	createStmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version int PRIMARY KEY, description text, checksum text, applied_at timestamp)", table)
+	if err := execSchemaStatement(ctx, session, createStmt); err != nil {
+		return nil, withParams(err, table)
+	}
+	applied, err := appliedMigrations(ctx, session, table)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := pendingMigrations(migrations, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	recordStmt := fmt.Sprintf("UPDATE %s SET applied_at = ? WHERE version = ?", table)
+	for i, m := range pending {
+		if err := claimMigration(ctx, session, table, m); err != nil {
+			return pending[:i], err
+		}
+		log.Info().Int("version", m.Version).Str("description", m.Description).Msg("applying migration")
+		for _, statement := range m.Statements {
+			if err := execSchemaStatement(ctx, session, statement); err != nil {
+				releaseMigration(session, table, m)
+				return pending[:i], withParams(err, m.Version, m.Description)
+			}
+		}
+		if cqlErr := session.Query(recordStmt, time.Now(), m.Version).WithContext(ctx).Exec(); cqlErr != nil {
+			return pending[:i], withParams(TranslateError(ctx, cqlErr, "cannot record migration"), m.Version, m.Description)
+		}
+	}
+	return pending, nil
+}
+
+// claimMigration records a migration as being applied if no other process has claimed it.
+func claimMigration(ctx context.Context, session *gocql.Session, table string, m Migration) derrors.Error {
+	claimStmt := fmt.Sprintf("INSERT INTO %s (version, description, checksum) VALUES (?, ?, ?) IF NOT EXISTS", table)
+	applied, cqlErr := session.Query(claimStmt, m.Version, m.Description, m.Checksum).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if cqlErr != nil {
+		return withParams(TranslateError(ctx, cqlErr, "cannot claim migration"), m.Version, m.Description)
+	}
+	if !applied {
+		return derrors.NewAbortedError(MigrationInProgressMsg).WithParams(m.Version, m.Description)
+	}
+	return nil
+}
+
+// releaseMigration removes the claim of a migration that failed, so it can be applied again once fixed. It does not
+// use the context of the migration, as it may be done.
+func releaseMigration(session *gocql.Session, table string, m Migration) {
+	releaseStmt := fmt.Sprintf("DELETE FROM %s WHERE version = ?", table)
+	if cqlErr := session.Query(releaseStmt, m.Version).Exec(); cqlErr != nil {
+		log.Warn().Str("err", cqlErr.Error()).Int("version", m.Version).Msg("cannot release the claim of the migration")
+	}
+}
+
+// MigrateFS loads the migrations of a file system with LoadMigrations and applies them with Migrate.
+func (s *ScyllaDB) MigrateFS(ctx context.Context, fsys fs.FS, options MigrateOptions) ([]Migration, derrors.Error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return s.Migrate(ctx, migrations, options)
+}
+
+// execSchemaStatement executes a statement that modifies the schema and waits for all the hosts to agree on it.
+func execSchemaStatement(ctx context.Context, session *gocql.Session, statement string) derrors.Error {
+	if cqlErr := session.Query(statement).WithContext(ctx).Exec(); cqlErr != nil {
+		return TranslateError(ctx, cqlErr, "cannot execute schema statement")
+	}
+	if cqlErr := session.AwaitSchemaAgreement(ctx); cqlErr != nil {
+		return TranslateError(ctx, cqlErr, "schema agreement not reached")
+	}
+	return nil
+}
+
+// appliedMigrations reads the migrations recorded in the migrations table indexed by version.
+func appliedMigrations(ctx context.Context, session *gocql.Session, table string) (map[int]appliedMigration, derrors.Error) {
+	applied := make(map[int]appliedMigration, 0)
+	iter := session.Query(fmt.Sprintf("SELECT version, description, checksum, applied_at FROM %s", table)).WithContext(ctx).Iter()
+	var m appliedMigration
+	for iter.Scan(&m.Version, &m.Description, &m.Checksum, &m.AppliedAt) {
+		applied[m.Version] = m
+	}
+	if cqlErr := iter.Close(); cqlErr != nil {
+		return nil, withParams(TranslateError(ctx, cqlErr, "cannot read applied migrations"), table)
+	}
+	return applied, nil
+}
+
+// pendingMigrations returns the migrations that have not been applied, checking that the applied ones have not
+// been modified and that no other process is applying them.
+func pendingMigrations(migrations []Migration, applied map[int]appliedMigration) ([]Migration, derrors.Error) {
+	pending := make([]Migration, 0)
+	for _, m := range migrations {
+		previous, exists := applied[m.Version]
+		if !exists {
+			pending = append(pending, m)
+			continue
+		}
+		if previous.Checksum != m.Checksum {
+			return nil, derrors.NewFailedPreconditionError(ChecksumMismatchMsg).WithParams(m.Version, m.Description, previous.Checksum, m.Checksum)
+		}
+		if previous.AppliedAt.IsZero() {
+			return nil, derrors.NewAbortedError(MigrationInProgressMsg).WithParams(m.Version, m.Description)
+		}
+	}
+	return pending, nil
+}
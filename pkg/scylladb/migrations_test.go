@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+	"testing/fstest"
+	"time"
+)
+
+var _ = ginkgo.Describe("Migrations", func() {
+
+	ginkgo.It("should split the statements of a script", func() {
+		script := `
+			-- first table; with a comment
+			CREATE TABLE a (id text PRIMARY KEY); /* block; comment */
+			INSERT INTO a (id) VALUES ('semi;colon''s');
+			CREATE FUNCTION f (x int) RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE lua AS $$ return x; $$
+		`
+		statements := SplitStatements(script)
+		gomega.Expect(statements).Should(gomega.Equal([]string{
+			"CREATE TABLE a (id text PRIMARY KEY)",
+			"INSERT INTO a (id) VALUES ('semi;colon''s')",
+			"CREATE FUNCTION f (x int) RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE lua AS $$ return x; $$",
+		}))
+	})
+	ginkgo.It("should load the migrations sorted by version", func() {
+		fsys := fstest.MapFS{
+			"010_add_column.cql":   {Data: []byte("ALTER TABLE a ADD name text;")},
+			"002_create_table.cql": {Data: []byte("CREATE TABLE a (id text PRIMARY KEY);")},
+			"README.md":            {Data: []byte("not a migration")},
+		}
+		migrations, err := LoadMigrations(fsys)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(migrations).Should(gomega.HaveLen(2))
+		gomega.Expect(migrations[0].Version).Should(gomega.Equal(2))
+		gomega.Expect(migrations[0].Description).Should(gomega.Equal("create_table"))
+		gomega.Expect(migrations[0].Statements).Should(gomega.Equal([]string{"CREATE TABLE a (id text PRIMARY KEY)"}))
+		gomega.Expect(migrations[1].Version).Should(gomega.Equal(10))
+		gomega.Expect(migrations[0].Checksum).ShouldNot(gomega.Equal(migrations[1].Checksum))
+	})
+	ginkgo.It("should load the migrations of a directory", func() {
+		migrations, err := LoadMigrations(os.DirFS("testdata/migrations"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(migrations).Should(gomega.HaveLen(2))
+		gomega.Expect(migrations[0].Statements).Should(gomega.HaveLen(2))
+	})
+	ginkgo.It("should reject duplicated versions", func() {
+		fsys := fstest.MapFS{
+			"001_create_table.cql": {Data: []byte("CREATE TABLE a (id text PRIMARY KEY);")},
+			"1_other_table.cql":    {Data: []byte("CREATE TABLE b (id text PRIMARY KEY);")},
+		}
+		_, err := LoadMigrations(fsys)
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+	})
+	ginkgo.It("should return the pending migrations", func() {
+		migrations := []Migration{{Version: 1, Checksum: "a"}, {Version: 2, Checksum: "b"}}
+		pending, err := pendingMigrations(migrations, map[int]appliedMigration{1: {Version: 1, Checksum: "a", AppliedAt: time.Now()}})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(pending).Should(gomega.Equal(migrations[1:]))
+	})
+	ginkgo.It("should refuse to run if a migration is being applied", func() {
+		migrations := []Migration{{Version: 1, Checksum: "a"}, {Version: 2, Checksum: "b"}}
+		_, err := pendingMigrations(migrations, map[int]appliedMigration{1: {Version: 1, Checksum: "a"}})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.Aborted))
+	})
+	ginkgo.It("should refuse to run if an applied migration changed", func() {
+		migrations := []Migration{{Version: 1, Checksum: "a"}, {Version: 2, Checksum: "b"}}
+		_, err := pendingMigrations(migrations, map[int]appliedMigration{1: {Version: 1, Checksum: "c"}})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NewFailedPreconditionError("").Type()))
+	})
+})
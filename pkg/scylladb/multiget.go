@@ -0,0 +1,288 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/scylladb/go-reflectx"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"reflect"
+	"sync"
+)
+
+// A multi-get reads the elements of a list of primary keys. Small sets of single column keys are read with a single
+// IN query, as long as the key column is one of the columns read and is stored in a field of the results, and the
+// rest are read with a query per key sent concurrently by a bounded pool of goroutines. The elements found are
+// appended to the results in the order of the keys, the duplicated keys are read once, and the keys not found are
+// returned instead of failing the whole call.
+
+// DefaultMultiGetParallelism is the default number of queries of a multi-get sent concurrently.
+const DefaultMultiGetParallelism = 8
+
+// DefaultMultiGetMaxInKeys is the default maximum number of keys read with an IN query.
+const DefaultMultiGetMaxInKeys = 10
+
+// MultiGetOptions contains the parameters of a multi-get.
+type MultiGetOptions struct {
+	// Parallelism is the maximum number of queries sent concurrently. DefaultMultiGetParallelism is used if zero.
+	Parallelism int
+	// MaxInKeys is the maximum number of keys read with an IN query. DefaultMultiGetMaxInKeys is used if zero, and
+	// IN queries are not used if negative.
+	MaxInKeys int
+}
+
+// UnsafeMultiGet retrieves the elements of a table identified by a list of single primary keys. The elements are
+// loaded into results, that must be a pointer to a slice of the struct where the data will be unmarshalled, or of
+// pointers to it. The keys that do not exist are returned.
+func (s *ScyllaDB) UnsafeMultiGet(table string, pkColumn string, pkValues []string, tableColumnNames []string, results interface{}, options MultiGetOptions) ([]string, derrors.Error) {
+	return s.UnsafeMultiGetContext(context.Background(), table, pkColumn, pkValues, tableColumnNames, results, options)
+}
+
+// UnsafeMultiGetContext is the context-aware version of UnsafeMultiGet. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeMultiGetContext(ctx context.Context, table string, pkColumn string, pkValues []string, tableColumnNames []string, results interface{}, options MultiGetOptions) ([]string, derrors.Error) {
+	keys := make([]map[string]interface{}, 0, len(pkValues))
+	for _, v := range pkValues {
+		keys = append(keys, map[string]interface{}{pkColumn: v})
+	}
+	missing, err := s.multiGet(ctx, table, keys, tableColumnNames, results, options)
+	if err != nil {
+		return nil, err
+	}
+	missingValues := make([]string, 0, len(missing))
+	for _, key := range missing {
+		missingValues = append(missingValues, key[pkColumn].(string))
+	}
+	return missingValues, nil
+}
+
+// UnsafeCompositeMultiGet retrieves the elements of a table identified by a list of composite primary keys. The
+// elements are loaded into results, that must be a pointer to a slice of the struct where the data will be
+// unmarshalled, or of pointers to it. The keys that do not exist are returned.
+func (s *ScyllaDB) UnsafeCompositeMultiGet(table string, pkColumns []map[string]interface{}, tableColumnNames []string, results interface{}, options MultiGetOptions) ([]map[string]interface{}, derrors.Error) {
+	return s.UnsafeCompositeMultiGetContext(context.Background(), table, pkColumns, tableColumnNames, results, options)
+}
+
+// UnsafeCompositeMultiGetContext is the context-aware version of UnsafeCompositeMultiGet. The context is passed to
+// all the queries.
+func (s *ScyllaDB) UnsafeCompositeMultiGetContext(ctx context.Context, table string, pkColumns []map[string]interface{}, tableColumnNames []string, results interface{}, options MultiGetOptions) ([]map[string]interface{}, derrors.Error) {
+	return s.multiGet(ctx, table, pkColumns, tableColumnNames, results, options)
+}
+
+// multiGet reads the elements of a list of keys into results and returns the keys not found.
+func (s *ScyllaDB) multiGet(ctx context.Context, table string, keys []map[string]interface{}, tableColumnNames []string, results interface{}, options MultiGetOptions) ([]map[string]interface{}, derrors.Error) {
+	slice, err := resultsSlice(results)
+	if err != nil {
+		return nil, err
+	}
+	keys = uniqueKeys(table, keys)
+	if len(keys) == 0 {
+		return []map[string]interface{}{}, nil
+	}
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []reflect.Value
+	if column, keyIndex, useIn := inColumn(keys, tableColumnNames, slice.Type().Elem(), options); useIn {
+		rows, err = s.getRowsIn(ctx, session, table, column, keyIndex, keys, tableColumnNames, slice.Type().Elem())
+	} else {
+		rows, err = s.getRows(ctx, session, table, keys, tableColumnNames, slice.Type().Elem(), options)
+	}
+	if err != nil {
+		return nil, withParams(err, table)
+	}
+
+	missing := make([]map[string]interface{}, 0)
+	for i, row := range rows {
+		if !row.IsValid() {
+			missing = append(missing, keys[i])
+			continue
+		}
+		if slice.Type().Elem().Kind() != reflect.Ptr {
+			row = row.Elem()
+		}
+		slice.Set(reflect.Append(slice, row))
+	}
+	return missing, nil
+}
+
+// getRows reads the element of each key with its own query, and returns a pointer to each element found in the
+// order of the keys.
+func (s *ScyllaDB) getRows(ctx context.Context, session *gocql.Session, table string, keys []map[string]interface{}, tableColumnNames []string, elemType reflect.Type, options MultiGetOptions) ([]reflect.Value, derrors.Error) {
+	parallelism := options.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultMultiGetParallelism
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rows := make([]reflect.Value, len(keys))
+	var mutex sync.Mutex
+	var firstErr derrors.Error
+	pending := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range pending {
+				row := reflect.New(structType(elemType))
+				err := s.getRow(ctx, session, table, keys[index], tableColumnNames, row.Interface())
+				mutex.Lock()
+				if err == nil {
+					rows[index] = row
+				} else if !IsNotFound(err) && firstErr == nil {
+					// the rest of queries are canceled
+					firstErr = withParams(err, getParams(keys[index]))
+					cancel()
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for index := range keys {
+		select {
+		case pending <- index:
+		case <-ctx.Done():
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, TranslateError(ctx, ctx.Err(), "cannot get elements")
+	}
+	return rows, nil
+}
+
+// getRow reads the element of a key.
+func (s *ScyllaDB) getRow(ctx context.Context, session *gocql.Session, table string, key map[string]interface{}, tableColumnNames []string, result interface{}) derrors.Error {
+	sb := qb.Select(table).Columns(tableColumnNames...)
+	for _, p := range sortedKeys(key) {
+		sb = sb.Where(qb.Eq(p))
+	}
+	stmt, names := sb.ToCql()
+	return s.execute(ctx, session, GetOperation, stmt, "cannot get element", func(q *gocql.Query) error {
+		return gocqlx.Query(q, names).BindMap(key).GetRelease(result)
+	})
+}
+
+// getRowsIn reads the elements of the keys of a single column with an IN query, and returns a pointer to each
+// element found in the order of the keys. The column is stored in the field with the keyIndex traversal.
+func (s *ScyllaDB) getRowsIn(ctx context.Context, session *gocql.Session, table string, column string, keyIndex []int, keys []map[string]interface{}, tableColumnNames []string, elemType reflect.Type) ([]reflect.Value, derrors.Error) {
+	values := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, key[column])
+	}
+	stmt, names := qb.Select(table).Columns(tableColumnNames...).Where(qb.In(column)).ToCql()
+	found := reflect.New(reflect.SliceOf(structType(elemType)))
+	err := s.execute(ctx, session, GetOperation, stmt, "cannot get elements", func(q *gocql.Query) error {
+		found.Elem().SetLen(0)
+		return gocqlx.Query(q, names).BindMap(qb.M{column: values}).SelectRelease(found.Interface())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]reflect.Value, found.Elem().Len())
+	for i := 0; i < found.Elem().Len(); i++ {
+		row := found.Elem().Index(i).Addr()
+		byKey[fmt.Sprint(reflectx.FieldByIndexesReadOnly(row.Elem(), keyIndex).Interface())] = row
+	}
+	rows := make([]reflect.Value, len(keys))
+	for i, key := range keys {
+		rows[i] = byKey[fmt.Sprint(key[column])]
+	}
+	return rows, nil
+}
+
+// inColumn returns the column of the keys and the traversal of its field in the elements if they can be read with an
+// IN query: there are few of them, they have a single column, and the column is read and stored in a field so the
+// elements can be matched with their keys.
+func inColumn(keys []map[string]interface{}, tableColumnNames []string, elemType reflect.Type, options MultiGetOptions) (string, []int, bool) {
+	maxInKeys := options.MaxInKeys
+	if maxInKeys == 0 {
+		maxInKeys = DefaultMultiGetMaxInKeys
+	}
+	if len(keys) > maxInKeys {
+		return "", nil, false
+	}
+	var column string
+	for _, key := range keys {
+		if len(key) != 1 {
+			return "", nil, false
+		}
+		for c := range key {
+			if column != "" && c != column {
+				return "", nil, false
+			}
+			column = c
+		}
+	}
+	field := gocqlx.DefaultMapper.TypeMap(structType(elemType)).GetByPath(column)
+	if field == nil {
+		return "", nil, false
+	}
+	for _, c := range tableColumnNames {
+		if c == column {
+			return column, field.Index, true
+		}
+	}
+	return "", nil, false
+}
+
+// resultsSlice returns the slice pointed by results, checking that it is a slice of structs or of pointers to them.
+func resultsSlice(results interface{}) (reflect.Value, derrors.Error) {
+	value := reflect.ValueOf(results)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Slice ||
+		structType(value.Elem().Type().Elem()).Kind() != reflect.Struct {
+		return reflect.Value{}, derrors.NewInvalidArgumentError("results must be a pointer to a slice of structs").WithParams(reflect.TypeOf(results))
+	}
+	return value.Elem(), nil
+}
+
+// structType returns the type pointed by a pointer type, or the type itself.
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// uniqueKeys removes the duplicated keys keeping the order.
+func uniqueKeys(table string, keys []map[string]interface{}) []map[string]interface{} {
+	seen := make(map[string]bool, len(keys))
+	unique := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		id := partitionID(table, key, sortedKeys(key))
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
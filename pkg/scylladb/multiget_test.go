@@ -0,0 +1,70 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"reflect"
+)
+
+var _ = ginkgo.Describe("Multi-get", func() {
+
+	ginkgo.It("should use IN queries for small sets of single column keys", func() {
+		keys := []map[string]interface{}{{"id1": "a"}, {"id1": "b"}}
+		elemType := reflect.TypeOf(CompositeStruct{})
+		column, keyIndex, useIn := inColumn(keys, AllTableColumns, elemType, MultiGetOptions{})
+		gomega.Expect(useIn).Should(gomega.BeTrue())
+		gomega.Expect(column).Should(gomega.Equal("id1"))
+		gomega.Expect(keyIndex).Should(gomega.Equal([]int{0}))
+
+		_, _, useIn = inColumn(keys, AllTableColumns, elemType, MultiGetOptions{MaxInKeys: 1})
+		gomega.Expect(useIn).Should(gomega.BeFalse())
+		_, _, useIn = inColumn(keys, AllTableColumns, elemType, MultiGetOptions{MaxInKeys: -1})
+		gomega.Expect(useIn).Should(gomega.BeFalse())
+		_, _, useIn = inColumn(keys, AllCompositeTableColumnsNoPK, elemType, MultiGetOptions{})
+		gomega.Expect(useIn).Should(gomega.BeFalse())
+		_, _, useIn = inColumn([]map[string]interface{}{{"id1": "a", "id2": "b"}}, AllTableColumns, elemType, MultiGetOptions{})
+		gomega.Expect(useIn).Should(gomega.BeFalse())
+	})
+	ginkgo.It("should not use IN queries if the key column is not mapped to a field", func() {
+		keys := []map[string]interface{}{{"id1": "a"}, {"id1": "b"}}
+		_, _, useIn := inColumn(keys, AllTableColumns, reflect.TypeOf(&RenamedStruct{}), MultiGetOptions{})
+		gomega.Expect(useIn).Should(gomega.BeFalse())
+	})
+	ginkgo.It("should remove the duplicated keys", func() {
+		keys := uniqueKeys(Table, []map[string]interface{}{{"id1": "a", "id2": "b"}, {"id1": "c", "id2": "d"}, {"id2": "b", "id1": "a"}})
+		gomega.Expect(keys).Should(gomega.Equal([]map[string]interface{}{{"id1": "a", "id2": "b"}, {"id1": "c", "id2": "d"}}))
+	})
+	ginkgo.It("should check the type of the results", func() {
+		_, err := resultsSlice(&[]CompositeStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = resultsSlice(&[]*CompositeStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = resultsSlice([]CompositeStruct{})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		_, err = resultsSlice(&[]string{})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+
+		results := make([]string, 0)
+		_, err = (&ScyllaDB{}).UnsafeMultiGet(BasicTable, "id1", []string{"a"}, AllTableColumns, &results, MultiGetOptions{})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.InvalidArgument))
+	})
+})
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// When the session is not created, the first goroutine that needs it reconnects following the ReconnectPolicy of
+// the ScyllaDB, while the rest wait for the result or fail fast. If the reconnection fails, no other reconnection is
+// attempted until the cooldown expires.
+
+// ReconnectingMsg is the message of the error returned while another goroutine is reconnecting.
+const ReconnectingMsg = "reconnecting to the cluster"
+
+// ReconnectCooldownMsg is the message of the error returned while the cooldown of a failed reconnection has not expired.
+const ReconnectCooldownMsg = "waiting to reconnect to the cluster"
+
+// ReconnectPolicy contains the parameters of the reconnection to the cluster.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of connection attempts of each reconnection.
+	MaxAttempts int
+	// InitialBackoff is the time to wait after the first failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum time to wait between attempts.
+	MaxBackoff time.Duration
+	// Multiplier is the factor the backoff is multiplied by after each failed attempt.
+	Multiplier float64
+	// Jitter is the fraction of the backoff that is randomly added or subtracted, between 0 and 1.
+	Jitter float64
+	// Cooldown is the time to wait after a failed reconnection before trying again. The calls received in the
+	// meantime fail with an Unavailable error.
+	Cooldown time.Duration
+	// FailFast returns an Unavailable error to the goroutines that need the session while another one is
+	// reconnecting, instead of waiting for it.
+	FailFast bool
+}
+
+// DefaultReconnectPolicy returns a policy with 5 attempts, an exponential backoff from 100ms to 5s with a jitter
+// of 20% and a cooldown of 10s.
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		Cooldown:       10 * time.Second,
+	}
+}
+
+// Backoff returns the time to wait after the given failed attempt, starting at zero.
+func (p *ReconnectPolicy) Backoff(attempt int) time.Duration {
+	return exponentialBackoff(p.InitialBackoff, p.MaxBackoff, p.Multiplier, p.Jitter, attempt)
+}
+
+// exponentialBackoff returns the time to wait after the given failed attempt, starting at zero.
+func exponentialBackoff(initial time.Duration, max time.Duration, multiplier float64, jitter float64, attempt int) time.Duration {
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(initial) * math.Pow(multiplier, float64(attempt))
+	if max > 0 && backoff > float64(max) {
+		backoff = float64(max)
+	}
+	if jitter > 0 {
+		backoff += backoff * math.Min(jitter, 1) * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// reconnection contains the state of the reconnection shared by the goroutines.
+type reconnection struct {
+	// done is closed when the reconnection in progress finishes, it is nil if there is none.
+	done chan struct{}
+	// err is the error of the last reconnection.
+	err derrors.Error
+	// next is the time when the cooldown of the last failed reconnection expires.
+	next time.Time
+}
+
+// reconnect creates the session following the ReconnectPolicy, or waits for the goroutine that is already doing it.
+// The context bounds both the reconnection and the wait.
+func (s *ScyllaDB) reconnect(ctx context.Context) (*gocql.Session, derrors.Error) {
+	policy := s.ReconnectPolicy
+	if policy == nil {
+		policy = &ReconnectPolicy{MaxAttempts: 1}
+	}
+
+	s.reconnectMutex.Lock()
+	if done := s.reconnection.done; done != nil {
+		s.reconnectMutex.Unlock()
+		if policy.FailFast {
+			return nil, derrors.NewUnavailableError(ReconnectingMsg)
+		}
+		select {
+		case <-done:
+			return s.reconnectResult()
+		case <-ctx.Done():
+			return nil, TranslateError(ctx, ctx.Err(), "cannot connect")
+		}
+	}
+	// another goroutine may have reconnected in the meantime
+	if session := s.currentSession(); session != nil {
+		s.reconnectMutex.Unlock()
+		return session, nil
+	}
+	if wait := time.Until(s.reconnection.next); wait > 0 {
+		s.reconnectMutex.Unlock()
+		return nil, derrors.NewUnavailableError(ReconnectCooldownMsg).WithParams(wait.String())
+	}
+	done := make(chan struct{})
+	s.reconnection.done = done
+	s.reconnectMutex.Unlock()
+
+	err := s.connectWithBackoff(ctx, policy)
+
+	s.reconnectMutex.Lock()
+	s.reconnection.done = nil
+	if err != nil && ctx.Err() != nil {
+		// the reconnection was cancelled by the caller, so the cluster may be available and the cooldown is not
+		// started; the goroutines that were waiting fail without its error
+		s.reconnection.err = nil
+		close(done)
+		s.reconnectMutex.Unlock()
+		return nil, err
+	}
+	s.reconnection.err = err
+	if err != nil {
+		s.reconnection.next = time.Now().Add(policy.Cooldown)
+	}
+	close(done)
+	s.reconnectMutex.Unlock()
+
+	return s.reconnectResult()
+}
+
+// connectWithBackoff tries to create the session up to the maximum number of attempts of the policy, until the
+// context is done.
+func (s *ScyllaDB) connectWithBackoff(ctx context.Context, policy *ReconnectPolicy) derrors.Error {
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err derrors.Error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(policy.Backoff(attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return TranslateError(ctx, ctx.Err(), "cannot connect")
+			case <-timer.C:
+			}
+		}
+		log.Info().Int("attempt", attempt+1).Int("attempts", attempts).Msg("session not created, trying to reconnect...")
+		if s.currentSession() != nil {
+			return nil
+		}
+		var session *gocql.Session
+		var hosts *hostTracker
+		session, hosts, err = s.connect(ctx)
+		if err == nil {
+			s.installSession(session, hosts)
+			return nil
+		}
+	}
+	return err
+}
+
+// installSession sets a session created by a reconnection, unless another one has been set in the meantime by
+// Connect. In that case, the new session is closed.
+func (s *ScyllaDB) installSession(session *gocql.Session, hosts *hostTracker) {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	if s.Session != nil {
+		session.Close()
+		return
+	}
+	s.Session = session
+	s.hosts = hosts
+}
+
+// reconnectResult returns the session created by the last reconnection or its error.
+func (s *ScyllaDB) reconnectResult() (*gocql.Session, derrors.Error) {
+	if session := s.currentSession(); session != nil {
+		return session, nil
+	}
+	s.reconnectMutex.Lock()
+	defer s.reconnectMutex.Unlock()
+	if s.reconnection.err != nil {
+		return nil, s.reconnection.err
+	}
+	return nil, derrors.NewUnavailableError("session not created")
+}
+
+// currentSession returns the session, that may be nil.
+func (s *ScyllaDB) currentSession() *gocql.Session {
+	s.sessionMutex.RLock()
+	defer s.sessionMutex.RUnlock()
+	return s.Session
+}
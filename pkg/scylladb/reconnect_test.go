@@ -0,0 +1,150 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+// unreachableConfig points to a local port without any server, so the connection attempts fail immediately.
+var unreachableConfig = &Config{Hosts: []string{"127.0.0.1"}, Port: 1, ConnectTimeout: 100 * time.Millisecond}
+
+var _ = ginkgo.Describe("Reconnection", func() {
+
+	ginkgo.It("should compute an exponential backoff", func() {
+		policy := ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+		gomega.Expect(policy.Backoff(0)).Should(gomega.Equal(100 * time.Millisecond))
+		gomega.Expect(policy.Backoff(1)).Should(gomega.Equal(200 * time.Millisecond))
+		gomega.Expect(policy.Backoff(3)).Should(gomega.Equal(800 * time.Millisecond))
+		gomega.Expect(policy.Backoff(4)).Should(gomega.Equal(time.Second))
+	})
+	ginkgo.It("should add the jitter to the backoff", func() {
+		policy := ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			backoff := policy.Backoff(1)
+			gomega.Expect(backoff).Should(gomega.BeNumerically(">=", 100*time.Millisecond))
+			gomega.Expect(backoff).Should(gomega.BeNumerically("<=", 300*time.Millisecond))
+		}
+	})
+	ginkgo.It("should return the connection error", func() {
+		sp := &ScyllaDB{Config: unreachableConfig}
+		err := sp.CheckAndConnect()
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).ShouldNot(gomega.Equal(derrors.NewUnavailableError("").Type()))
+	})
+	ginkgo.It("should not reconnect during the cooldown", func() {
+		sp := &ScyllaDB{Config: unreachableConfig, ReconnectPolicy: &ReconnectPolicy{MaxAttempts: 1, Cooldown: time.Minute}}
+		gomega.Expect(sp.CheckAndConnect()).ShouldNot(gomega.Succeed())
+		err := sp.CheckAndConnect()
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NewUnavailableError("").Type()))
+		gomega.Expect(err.Error()).Should(gomega.ContainSubstring(ReconnectCooldownMsg))
+	})
+	ginkgo.It("should fail fast while another goroutine reconnects", func() {
+		sp := &ScyllaDB{Config: unreachableConfig, ReconnectPolicy: &ReconnectPolicy{MaxAttempts: 2, InitialBackoff: 500 * time.Millisecond, FailFast: true}}
+		finished := make(chan derrors.Error, 1)
+		go func() {
+			finished <- sp.CheckAndConnect()
+		}()
+		gomega.Eventually(func() bool {
+			sp.reconnectMutex.Lock()
+			defer sp.reconnectMutex.Unlock()
+			return sp.reconnection.done != nil
+		}).Should(gomega.BeTrue())
+		err := sp.CheckAndConnect()
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Error()).Should(gomega.ContainSubstring(ReconnectingMsg))
+		gomega.Eventually(finished, 5*time.Second).Should(gomega.Receive(gomega.HaveOccurred()))
+	})
+	ginkgo.It("should wait for the goroutine that reconnects", func() {
+		sp := &ScyllaDB{Config: unreachableConfig, ReconnectPolicy: &ReconnectPolicy{MaxAttempts: 2, InitialBackoff: 500 * time.Millisecond}}
+		finished := make(chan derrors.Error, 1)
+		go func() {
+			finished <- sp.CheckAndConnect()
+		}()
+		gomega.Eventually(func() bool {
+			sp.reconnectMutex.Lock()
+			defer sp.reconnectMutex.Unlock()
+			return sp.reconnection.done != nil
+		}).Should(gomega.BeTrue())
+		start := time.Now()
+		err := sp.CheckAndConnect()
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Error()).ShouldNot(gomega.ContainSubstring(ReconnectingMsg))
+		gomega.Expect(time.Since(start)).Should(gomega.BeNumerically(">", 100*time.Millisecond))
+		gomega.Eventually(finished, 5*time.Second).Should(gomega.Receive(gomega.HaveOccurred()))
+	})
+	ginkgo.It("should stop the backoff when the context is done", func() {
+		sp := &ScyllaDB{Config: unreachableConfig, ReconnectPolicy: &ReconnectPolicy{MaxAttempts: 2, InitialBackoff: time.Hour, Cooldown: time.Minute}}
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := sp.getSession(ctx)
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.DeadlineExceeded))
+		gomega.Expect(time.Since(start)).Should(gomega.BeNumerically("<", 5*time.Second))
+		// the cancelled reconnection does not start the cooldown
+		gomega.Expect(sp.reconnection.next.IsZero()).Should(gomega.BeTrue())
+	})
+	ginkgo.It("should stop waiting for the goroutine that reconnects when the context is done", func() {
+		sp := &ScyllaDB{Config: unreachableConfig, ReconnectPolicy: &ReconnectPolicy{MaxAttempts: 2, InitialBackoff: time.Second}}
+		finished := make(chan derrors.Error, 1)
+		go func() {
+			finished <- sp.CheckAndConnect()
+		}()
+		gomega.Eventually(func() bool {
+			sp.reconnectMutex.Lock()
+			defer sp.reconnectMutex.Unlock()
+			return sp.reconnection.done != nil
+		}).Should(gomega.BeTrue())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := sp.getSession(ctx)
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.Canceled))
+		gomega.Expect(finished).ShouldNot(gomega.Receive())
+		gomega.Eventually(finished, 5*time.Second).Should(gomega.Receive(gomega.HaveOccurred()))
+	})
+	ginkgo.It("should not hold the session lock while reconnecting", func() {
+		sp := &ScyllaDB{Config: unreachableConfig, ReconnectPolicy: &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 200 * time.Millisecond}}
+		finished := make(chan derrors.Error, 1)
+		go func() {
+			finished <- sp.CheckAndConnect()
+		}()
+		for len(finished) == 0 {
+			start := time.Now()
+			gomega.Expect(sp.CheckConnection()).ShouldNot(gomega.Succeed())
+			gomega.Expect(time.Since(start)).Should(gomega.BeNumerically("<", 50*time.Millisecond))
+			time.Sleep(time.Millisecond)
+		}
+		gomega.Expect(<-finished).ShouldNot(gomega.Succeed())
+	})
+	ginkgo.It("should close the session of a reconnection if another one is set", func() {
+		current := &gocql.Session{}
+		sp := &ScyllaDB{Session: current}
+		created := &gocql.Session{}
+		sp.installSession(created, nil)
+		gomega.Expect(sp.Session).Should(gomega.BeIdenticalTo(current))
+		gomega.Expect(created.Closed()).Should(gomega.BeTrue())
+	})
+})
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+)
+
+// Repository provides the basic operations over a table whose rows are stored in structs of type T. The columns of
+// the table are derived from the fields of T as described in TableMetadata. A Repository can be used from several
+// goroutines.
+type Repository[T any] struct {
+	db       *ScyllaDB
+	metadata *TableMetadata
+}
+
+// NewRepository creates a Repository over a table whose primary key is composed of the partitionKey and the
+// clusteringKey columns.
+func NewRepository[T any](db *ScyllaDB, table string, partitionKey []string, clusteringKey []string) (*Repository[T], derrors.Error) {
+	md, err := NewTableMetadataWithKeys(table, new(T), partitionKey, clusteringKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Repository[T]{db: db, metadata: md}, nil
+}
+
+// NewTaggedRepository creates a Repository over a table whose primary key is declared with the pk and ck tag options
+// of the fields of T.
+func NewTaggedRepository[T any](db *ScyllaDB, table string) (*Repository[T], derrors.Error) {
+	md, err := NewTableMetadata(table, new(T))
+	if err != nil {
+		return nil, err
+	}
+	return &Repository[T]{db: db, metadata: md}, nil
+}
+
+// Table returns the name of the table of the repository.
+func (r *Repository[T]) Table() string {
+	return r.metadata.Table
+}
+
+// Columns returns the names of all the columns of the table.
+func (r *Repository[T]) Columns() []string {
+	return r.metadata.Columns
+}
+
+// Metadata returns the metadata of the table.
+func (r *Repository[T]) Metadata() *TableMetadata {
+	return r.metadata
+}
+
+// ValidateSchema compares the table of the repository with the table in the database. See ScyllaDB.ValidateTable.
+func (r *Repository[T]) ValidateSchema() (*SchemaDrift, derrors.Error) {
+	return r.db.ValidateTable(r.metadata)
+}
+
+// Key returns the values of the primary key of an entity indexed by the column name.
+func (r *Repository[T]) Key(entity *T) map[string]interface{} {
+	// the metadata is built from T, so the entity always matches it
+	key, _ := r.metadata.KeyValues(entity)
+	return key
+}
+
+// Add adds a new entity to the table.
+func (r *Repository[T]) Add(entity *T) derrors.Error {
+	return r.db.UnsafeEntityAdd(r.metadata, entity)
+}
+
+// Update updates all the columns of an entity that do not belong to the primary key.
+func (r *Repository[T]) Update(entity *T) derrors.Error {
+	return r.db.UnsafeEntityUpdate(r.metadata, entity)
+}
+
+// UpdateFields updates the columns of an entity that correspond to the paths of a field mask.
+func (r *Repository[T]) UpdateFields(entity *T, paths []string) derrors.Error {
+	return r.db.UnsafeEntityUpdateFields(r.metadata, entity, paths)
+}
+
+// Upsert adds an entity or overwrites it if it already exists.
+func (r *Repository[T]) Upsert(entity *T) derrors.Error {
+	return r.db.UnsafeEntityUpsert(r.metadata, entity)
+}
+
+// Get retrieves the entity identified by the values of its primary key.
+func (r *Repository[T]) Get(key map[string]interface{}) (*T, derrors.Error) {
+	var result interface{} = new(T)
+	if err := r.db.compositeGet(context.Background(), r.metadata.mapper, r.metadata.Table, key, r.metadata.Columns, result); err != nil {
+		return nil, err
+	}
+	return result.(*T), nil
+}
+
+// Exists checks if the entity identified by the values of its primary key exists.
+func (r *Repository[T]) Exists(key map[string]interface{}) (bool, derrors.Error) {
+	return r.db.UnsafeGenericCompositeExist(r.metadata.Table, key)
+}
+
+// Remove removes the entity identified by the values of its primary key.
+func (r *Repository[T]) Remove(key map[string]interface{}) derrors.Error {
+	return r.db.UnsafeCompositeRemove(r.metadata.Table, key)
+}
+
+// List retrieves a page of entities. If partition is not empty, only the entities of that partition are returned.
+// See UnsafeList for the use of the page tokens.
+func (r *Repository[T]) List(partition map[string]interface{}, pageSize int, pageToken []byte) ([]T, []byte, derrors.Error) {
+	result := make([]T, 0)
+	nextToken, err := r.db.list(r.metadata.mapper, r.metadata.Table, partition, r.metadata.Columns, pageSize, pageToken, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, nextToken, nil
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Repository", func() {
+
+	ginkgo.It("should derive the columns from the struct tags", func() {
+		repository, err := NewRepository[CompositeStruct](&ScyllaDB{}, Table, []string{"id1"}, []string{"id2"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(repository.Columns()).Should(gomega.Equal(AllTableColumns))
+
+		compo := GetCompositeStruct()
+		gomega.Expect(repository.Key(compo)).Should(gomega.Equal(GetCompositeValues(*compo)))
+	})
+	ginkgo.It("should not be able to use key columns that do not exist", func() {
+		_, err := NewRepository[CompositeStruct](&ScyllaDB{}, Table, []string{"id1"}, []string{"id4"})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+	ginkgo.It("should not be able to use entities that are not structs", func() {
+		_, err := NewRepository[string](&ScyllaDB{}, Table, []string{"id1"}, nil)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+})
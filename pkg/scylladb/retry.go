@@ -0,0 +1,180 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The queries of the CRUD functions are retried following the RetryPolicy of their operation when they fail with a
+// retryable error. Only idempotent statements are retried: lightweight transactions, counter updates, list appends
+// and statements generating values such as now() may have been applied by the failed attempt, so they are never
+// retried whatever the policy says.
+
+// Operation identifies the kind of query executed by a CRUD function, to choose its retry policy.
+type Operation string
+
+const (
+	// ExistOperation is the query that checks if an element exists.
+	ExistOperation Operation = "exist"
+	// GetOperation is the query that retrieves an element.
+	GetOperation Operation = "get"
+	// AddOperation is the query that inserts an element.
+	AddOperation Operation = "add"
+	// UpdateOperation is the query that updates an element.
+	UpdateOperation Operation = "update"
+	// UpsertOperation is the query that inserts or overwrites an element.
+	UpsertOperation Operation = "upsert"
+	// RemoveOperation is the query that removes an element.
+	RemoveOperation Operation = "remove"
+	// BatchOperation is the execution of a batch.
+	BatchOperation Operation = "batch"
+)
+
+// AttemptsParam is the key of the parameter with the number of attempts added to the errors of retried queries.
+const AttemptsParam = "attempts"
+
+// lwtRegexp matches the conditions of lightweight transactions.
+var lwtRegexp = regexp.MustCompile(`(?i)\bIF\b`)
+
+// incrementRegexp matches the assignments that add to the current value of a column, as counters and lists do.
+var incrementRegexp = regexp.MustCompile(`(\w+)\s*=\s*(\w+)\s*[-+]|(\w+)\s*=\s*\?\s*\+\s*(\w+)`)
+
+// generatedValueRegexp matches the functions that generate a different value in each call.
+var generatedValueRegexp = regexp.MustCompile(`(?i)\b(now|uuid)\s*\(`)
+
+// RetryPolicy contains the parameters of the retries of a query.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a query is executed, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the time to wait after the first failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum time to wait between attempts.
+	MaxBackoff time.Duration
+	// Multiplier is the factor the backoff is multiplied by after each failed attempt.
+	Multiplier float64
+	// Jitter is the fraction of the backoff that is randomly added or subtracted, between 0 and 1.
+	Jitter float64
+	// DowngradeConsistency contains the consistency levels used by the retries, in order. The last one is used if
+	// there are more retries than levels. If empty, the retries use the consistency of the session.
+	DowngradeConsistency []gocql.Consistency
+}
+
+// DefaultRetryPolicy returns a policy with 3 attempts and an exponential backoff from 50ms to 1s with a jitter of 20%.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff returns the time to wait after the given failed attempt, starting at zero.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	return exponentialBackoff(p.InitialBackoff, p.MaxBackoff, p.Multiplier, p.Jitter, attempt)
+}
+
+// consistency returns the consistency level of the given attempt, starting at zero, and whether it must be set.
+func (p *RetryPolicy) consistency(attempt int) (gocql.Consistency, bool) {
+	if p == nil || attempt == 0 || len(p.DowngradeConsistency) == 0 {
+		return 0, false
+	}
+	if attempt > len(p.DowngradeConsistency) {
+		attempt = len(p.DowngradeConsistency)
+	}
+	return p.DowngradeConsistency[attempt-1], true
+}
+
+// IsIdempotent checks if a statement can be executed several times with the same result, so it can be retried.
+// Lightweight transactions, counter updates, list appends and statements using now() or uuid() are not idempotent.
+func IsIdempotent(stmt string) bool {
+	if lwtRegexp.MatchString(stmt) || generatedValueRegexp.MatchString(stmt) {
+		return false
+	}
+	for _, match := range incrementRegexp.FindAllStringSubmatch(stmt, -1) {
+		if strings.EqualFold(match[1], match[2]) || strings.EqualFold(match[3], match[4]) {
+			return false
+		}
+	}
+	return true
+}
+
+// retryPolicy returns the retry policy of an operation, that may be nil.
+func (s *ScyllaDB) retryPolicy(operation Operation) *RetryPolicy {
+	if policy, exists := s.RetryPolicies[operation]; exists {
+		return policy
+	}
+	return s.RetryPolicy
+}
+
+// execute runs a statement following the retry policy of the operation. The query of each attempt is created by
+// execute and passed to run, that binds and executes it.
+func (s *ScyllaDB) execute(ctx context.Context, session *gocql.Session, operation Operation, stmt string, msg string, run func(q *gocql.Query) error) derrors.Error {
+	policy := s.retryPolicy(operation)
+	idempotent := IsIdempotent(stmt)
+	return retry(ctx, policy, operation, idempotent, msg, func(attempt int) error {
+		q := session.Query(stmt).WithContext(ctx).Idempotent(idempotent)
+		if consistency, downgrade := policy.consistency(attempt); downgrade {
+			q = q.Consistency(consistency)
+		}
+		return run(q)
+	})
+}
+
+// retry calls run until it succeeds, it fails with an error that is not retryable or the attempts of the policy are
+// exhausted. Statements that are not idempotent are run only once.
+func retry(ctx context.Context, policy *RetryPolicy, operation Operation, idempotent bool, msg string, run func(attempt int) error) derrors.Error {
+	attempts := 1
+	if idempotent && policy != nil && policy.MaxAttempts > 1 {
+		attempts = policy.MaxAttempts
+	}
+	for attempt := 0; ; attempt++ {
+		err := TranslateError(ctx, run(attempt), msg)
+		if err == nil {
+			if attempt > 0 {
+				log.Info().Str("operation", string(operation)).Int(AttemptsParam, attempt+1).Msg("query succeeded after retrying")
+			}
+			return nil
+		}
+		if attempt+1 >= attempts || !IsRetryable(err) {
+			if attempt > 0 {
+				log.Warn().Str("operation", string(operation)).Int(AttemptsParam, attempt+1).Str("err", err.Error()).Msg("query failed after retrying")
+				return withParams(err, map[string]int{AttemptsParam: attempt + 1})
+			}
+			return err
+		}
+		backoff := policy.Backoff(attempt)
+		log.Warn().Str("operation", string(operation)).Int("attempt", attempt+1).Int(AttemptsParam, attempts).
+			Dur("backoff", backoff).Str("err", err.Error()).Msg("query failed, retrying")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return withParams(TranslateError(ctx, ctx.Err(), msg), map[string]int{AttemptsParam: attempt + 1})
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"errors"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+// testRetryPolicy retries immediately.
+var testRetryPolicy = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+
+var _ = ginkgo.Describe("Retries", func() {
+
+	ginkgo.It("should detect the idempotent statements", func() {
+		gomega.Expect(IsIdempotent("SELECT * FROM t WHERE id=?")).Should(gomega.BeTrue())
+		gomega.Expect(IsIdempotent("DELETE FROM t WHERE id=?")).Should(gomega.BeTrue())
+		gomega.Expect(IsIdempotent("INSERT INTO t (id,name) VALUES (?,?)")).Should(gomega.BeTrue())
+		gomega.Expect(IsIdempotent("UPDATE t SET name=?, notify=? WHERE id=?")).Should(gomega.BeTrue())
+		gomega.Expect(IsIdempotent("INSERT INTO t (id,name) VALUES (?,?) IF NOT EXISTS")).Should(gomega.BeFalse())
+		gomega.Expect(IsIdempotent("UPDATE t SET name=? WHERE id=? IF version=?")).Should(gomega.BeFalse())
+		gomega.Expect(IsIdempotent("DELETE FROM t WHERE id=? IF EXISTS")).Should(gomega.BeFalse())
+		gomega.Expect(IsIdempotent("UPDATE t SET hits = hits + ? WHERE id=?")).Should(gomega.BeFalse())
+		gomega.Expect(IsIdempotent("UPDATE t SET tags = ? + tags WHERE id=?")).Should(gomega.BeFalse())
+		gomega.Expect(IsIdempotent("INSERT INTO t (id,created) VALUES (?,now())")).Should(gomega.BeFalse())
+	})
+	ginkgo.It("should downgrade the consistency of the retries", func() {
+		policy := &RetryPolicy{DowngradeConsistency: []gocql.Consistency{gocql.LocalQuorum, gocql.One}}
+		_, downgrade := policy.consistency(0)
+		gomega.Expect(downgrade).Should(gomega.BeFalse())
+		consistency, downgrade := policy.consistency(1)
+		gomega.Expect(downgrade).Should(gomega.BeTrue())
+		gomega.Expect(consistency).Should(gomega.Equal(gocql.LocalQuorum))
+		consistency, _ = policy.consistency(5)
+		gomega.Expect(consistency).Should(gomega.Equal(gocql.One))
+	})
+	ginkgo.It("should retry the retryable errors", func() {
+		calls := 0
+		err := retry(context.Background(), testRetryPolicy, GetOperation, true, "cannot get element", func(attempt int) error {
+			calls++
+			if attempt < 2 {
+				return gocql.ErrTimeoutNoResponse
+			}
+			return nil
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(calls).Should(gomega.Equal(3))
+	})
+	ginkgo.It("should report the attempts when they are exhausted", func() {
+		calls := 0
+		err := retry(context.Background(), testRetryPolicy, GetOperation, true, "cannot get element", func(attempt int) error {
+			calls++
+			return &gocql.RequestErrReadTimeout{}
+		})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(calls).Should(gomega.Equal(3))
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.DeadlineExceeded))
+		gomega.Expect(err.(*derrors.GenericError).Parameters).Should(gomega.ContainElement(`{"attempts":3}`))
+	})
+	ginkgo.It("should not retry the errors that are not retryable", func() {
+		calls := 0
+		err := retry(context.Background(), testRetryPolicy, GetOperation, true, "cannot get element", func(attempt int) error {
+			calls++
+			return errors.New("query failed")
+		})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(calls).Should(gomega.Equal(1))
+	})
+	ginkgo.It("should not retry the statements that are not idempotent", func() {
+		calls := 0
+		err := retry(context.Background(), testRetryPolicy, UpdateOperation, false, "cannot update element", func(attempt int) error {
+			calls++
+			return &gocql.RequestErrWriteTimeout{WriteType: "SIMPLE"}
+		})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(calls).Should(gomega.Equal(1))
+	})
+	ginkgo.It("should stop retrying when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute}
+		err := retry(ctx, policy, GetOperation, true, "cannot get element", func(attempt int) error {
+			cancel()
+			return gocql.ErrNoConnections
+		})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.Canceled))
+	})
+	ginkgo.It("should use the policy of the operation", func() {
+		sp := &ScyllaDB{RetryPolicy: testRetryPolicy, RetryPolicies: map[Operation]*RetryPolicy{AddOperation: nil}}
+		gomega.Expect(sp.retryPolicy(GetOperation)).Should(gomega.Equal(testRetryPolicy))
+		gomega.Expect(sp.retryPolicy(AddOperation)).Should(gomega.BeNil())
+	})
+})
@@ -0,0 +1,264 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/gocqlx"
+	"math"
+	"strings"
+	"sync"
+)
+
+// A full table scan splits the Murmur3 token ring in several sub-ranges that are queried concurrently with
+// token(pk) > ? AND token(pk) <= ?. The progress of each sub-range is tracked page by page in a ScanCheckpoint
+// so a failed scan can be resumed. Rows of the page being processed when the scan fails are delivered again on
+// resume, so callbacks must be idempotent.
+
+// DefaultScanSplits is the number of sub-ranges the token ring is split into if not specified.
+const DefaultScanSplits = 64
+
+// DefaultScanParallelism is the number of sub-ranges queried concurrently if not specified.
+const DefaultScanParallelism = 8
+
+// DefaultScanPageSize is the number of rows requested per page if not specified.
+const DefaultScanPageSize = 1000
+
+// TokenRange is a range of the token ring, including End but not Start.
+type TokenRange struct {
+	Start int64
+	End   int64
+}
+
+// ScanRangeProgress contains the progress of the scan of a token range.
+type ScanRangeProgress struct {
+	TokenRange
+	// PageState is the page state of the next page to be processed.
+	PageState []byte
+	// Done is true when all the rows of the range have been processed.
+	Done bool
+}
+
+// ScanCheckpoint contains the progress of a full table scan.
+type ScanCheckpoint struct {
+	Ranges []ScanRangeProgress
+}
+
+// Done returns true if all the token ranges have been scanned.
+func (c *ScanCheckpoint) Done() bool {
+	for _, r := range c.Ranges {
+		if !r.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanOptions contains the parameters of a full table scan.
+type ScanOptions struct {
+	// Splits is the number of sub-ranges the token ring is split into.
+	Splits int
+	// Parallelism is the number of sub-ranges queried concurrently.
+	Parallelism int
+	// PageSize is the number of rows requested per page.
+	PageSize int
+	// Checkpoint is the progress of a previous scan to be resumed. Splits is ignored if set.
+	Checkpoint *ScanCheckpoint
+}
+
+// SplitTokenRing splits the Murmur3 token ring in the given number of contiguous sub-ranges of the same size.
+func SplitTokenRing(splits int) []TokenRange {
+	if splits <= 0 {
+		splits = 1
+	}
+	width := uint64(math.MaxUint64) / uint64(splits)
+	ranges := make([]TokenRange, splits)
+	start := int64(math.MinInt64)
+	for i := 0; i < splits; i++ {
+		end := int64(uint64(start) + width)
+		if i == splits-1 {
+			end = math.MaxInt64
+		}
+		ranges[i] = TokenRange{Start: start, End: end}
+		start = end
+	}
+	return ranges
+}
+
+// UnsafeScan reads all the rows of a table whose partition key is composed of pkColumns. Each row is loaded into a
+// new element obtained from newRow, that must return a pointer to the struct where the data will be unmarshalled,
+// and passed to callback. The callback is invoked concurrently from several goroutines. The scan stops at the first
+// error, and the returned checkpoint can be passed in the options to resume it.
+func (s *ScyllaDB) UnsafeScan(table string, pkColumns []string, tableColumnNames []string, newRow func() interface{}, callback func(row interface{}) error, options ScanOptions) (*ScanCheckpoint, derrors.Error) {
+	// check connection
+	session, err := s.getSession(context.Background())
+	if err != nil {
+		return options.Checkpoint, err
+	}
+
+	checkpoint := options.Checkpoint
+	if checkpoint == nil {
+		splits := options.Splits
+		if splits <= 0 {
+			splits = DefaultScanSplits
+		}
+		checkpoint = &ScanCheckpoint{}
+		for _, r := range SplitTokenRing(splits) {
+			checkpoint.Ranges = append(checkpoint.Ranges, ScanRangeProgress{TokenRange: r})
+		}
+	}
+	parallelism := options.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultScanParallelism
+	}
+	pageSize := options.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultScanPageSize
+	}
+
+	token := fmt.Sprintf("token(%s)", strings.Join(pkColumns, ", "))
+	stmt := fmt.Sprintf("SELECT %s FROM %s WHERE %s > ? AND %s <= ?", strings.Join(tableColumnNames, ", "), table, token, token)
+
+	scanner := &tableScanner{
+		session:    session,
+		stmt:       stmt,
+		pageSize:   pageSize,
+		newRow:     newRow,
+		callback:   callback,
+		checkpoint: copyCheckpoint(checkpoint),
+	}
+
+	pending := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range pending {
+				scanner.scanRange(index)
+			}
+		}()
+	}
+	for index, r := range checkpoint.Ranges {
+		if !r.Done {
+			pending <- index
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	result := scanner.snapshot()
+	if scanner.err != nil {
+		log.Error().Str("table", table).Str("trace", scanner.err.DebugReport()).Msg("table scan failed")
+		return result, scanner.err
+	}
+	return result, nil
+}
+
+// tableScanner contains the state shared by the goroutines of a full table scan.
+type tableScanner struct {
+	session  *gocql.Session
+	stmt     string
+	pageSize int
+	newRow   func() interface{}
+	callback func(row interface{}) error
+
+	sync.Mutex
+	checkpoint *ScanCheckpoint
+	// err contains the first error found, the pending ranges are skipped once it is set.
+	err derrors.Error
+}
+
+// scanRange reads the pages of a token range until it is finished or an error is found.
+func (ts *tableScanner) scanRange(index int) {
+	ts.Lock()
+	progress := ts.checkpoint.Ranges[index]
+	ts.Unlock()
+
+	for {
+		if ts.failed() {
+			return
+		}
+
+		q := ts.session.Query(ts.stmt, progress.Start, progress.End).PageSize(ts.pageSize).PageState(progress.PageState)
+		iter := gocqlx.Iter(q)
+		row := ts.newRow()
+		for iter.StructScan(row) {
+			if err := ts.callback(row); err != nil {
+				iter.Close()
+				ts.fail(derrors.NewGenericError("scan callback failed", err).WithParams(progress.Start, progress.End))
+				return
+			}
+			row = ts.newRow()
+		}
+		if err := iter.Close(); err != nil {
+			ts.fail(withParams(TranslateError(context.Background(), err, "cannot scan token range"), progress.Start, progress.End))
+			return
+		}
+
+		progress.PageState = iter.PageState()
+		progress.Done = len(progress.PageState) == 0
+
+		ts.Lock()
+		ts.checkpoint.Ranges[index] = progress
+		ts.Unlock()
+
+		if progress.Done {
+			return
+		}
+	}
+}
+
+// failed returns true if any of the ranges has failed.
+func (ts *tableScanner) failed() bool {
+	ts.Lock()
+	defer ts.Unlock()
+	return ts.err != nil
+}
+
+// fail records the error of a range, only the first one is kept.
+func (ts *tableScanner) fail(err derrors.Error) {
+	ts.Lock()
+	defer ts.Unlock()
+	if ts.err == nil {
+		ts.err = err
+	}
+}
+
+// snapshot returns a copy of the current checkpoint.
+func (ts *tableScanner) snapshot() *ScanCheckpoint {
+	ts.Lock()
+	defer ts.Unlock()
+	return copyCheckpoint(ts.checkpoint)
+}
+
+// copyCheckpoint returns a deep copy of a checkpoint.
+func copyCheckpoint(checkpoint *ScanCheckpoint) *ScanCheckpoint {
+	result := &ScanCheckpoint{Ranges: make([]ScanRangeProgress, len(checkpoint.Ranges))}
+	for i, r := range checkpoint.Ranges {
+		result.Ranges[i] = r
+		if r.PageState != nil {
+			result.Ranges[i].PageState = append([]byte(nil), r.PageState...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"math"
+)
+
+var _ = ginkgo.Describe("Token ring split", func() {
+
+	ginkgo.It("should cover the whole ring with contiguous ranges", func() {
+		for _, splits := range []int{1, 2, 3, 64, 1000} {
+			ranges := SplitTokenRing(splits)
+			gomega.Expect(ranges).Should(gomega.HaveLen(splits))
+			gomega.Expect(ranges[0].Start).Should(gomega.Equal(int64(math.MinInt64)))
+			gomega.Expect(ranges[splits-1].End).Should(gomega.Equal(int64(math.MaxInt64)))
+			for i := 1; i < splits; i++ {
+				gomega.Expect(ranges[i].Start).Should(gomega.Equal(ranges[i-1].End))
+				gomega.Expect(ranges[i].Start).Should(gomega.BeNumerically(">", ranges[i-1].Start))
+			}
+		}
+	})
+	ginkgo.It("should return a single range for invalid splits", func() {
+		gomega.Expect(SplitTokenRing(0)).Should(gomega.HaveLen(1))
+	})
+})
@@ -0,0 +1,297 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/scylladb/go-reflectx"
+	"math/big"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The schema helpers create keyspaces and tables for tests and prototypes; use migrations to manage the schema of a
+// deployment. The CQL type of a column is derived from the Go type of its field:
+//
+//	string              text
+//	bool                boolean
+//	int8, int16, int32  tinyint, smallint, int
+//	int, int64          bigint
+//	float32, float64    float, double
+//	[]byte              blob
+//	gocql.UUID          uuid
+//	time.Time           timestamp
+//	net.IP              inet
+//	big.Int             varint
+//	[]T                 list<T>
+//	map[K]V             map<K, V>
+//	struct              frozen<type>, a user defined type named after the struct in snake case
+//
+// Pointers are mapped as the type they point to. The fields of a user defined type are named after their `cql` tag,
+// as gocql requires it to marshal them.
+
+// identifierRegexp matches the names that can be used as keyspace names.
+var identifierRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// Types with a specific CQL type.
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	uuidType   = reflect.TypeOf(gocql.UUID{})
+	ipType     = reflect.TypeOf(net.IP{})
+	bigIntType = reflect.TypeOf(big.Int{})
+	bytesType  = reflect.TypeOf([]byte{})
+)
+
+// Replication contains the replication strategy of a keyspace.
+type Replication struct {
+	// Class is the name of the replication strategy.
+	Class string
+	// ReplicationFactor is the number of replicas of the SimpleStrategy.
+	ReplicationFactor int
+	// DataCenters contains the number of replicas in each datacenter of the NetworkTopologyStrategy.
+	DataCenters map[string]int
+}
+
+// SimpleReplication returns a SimpleStrategy replication, to be used in single datacenter clusters.
+func SimpleReplication(replicationFactor int) Replication {
+	return Replication{Class: "SimpleStrategy", ReplicationFactor: replicationFactor}
+}
+
+// NetworkTopologyReplication returns a NetworkTopologyStrategy replication with the number of replicas of each
+// datacenter.
+func NetworkTopologyReplication(dataCenters map[string]int) Replication {
+	return Replication{Class: "NetworkTopologyStrategy", DataCenters: dataCenters}
+}
+
+// cql returns the replication map of the strategy.
+func (r Replication) cql() string {
+	options := []string{fmt.Sprintf("'class': %s", quoteString(r.Class))}
+	if r.Class == "SimpleStrategy" {
+		options = append(options, fmt.Sprintf("'replication_factor': %d", r.ReplicationFactor))
+	}
+	dataCenters := make([]string, 0, len(r.DataCenters))
+	for dc := range r.DataCenters {
+		dataCenters = append(dataCenters, dc)
+	}
+	sort.Strings(dataCenters)
+	for _, dc := range dataCenters {
+		options = append(options, fmt.Sprintf("%s: %d", quoteString(dc), r.DataCenters[dc]))
+	}
+	return "{" + strings.Join(options, ", ") + "}"
+}
+
+// CreateKeyspaceStatement returns the statement that creates a keyspace if it does not exist.
+func CreateKeyspaceStatement(keyspace string, replication Replication) (string, derrors.Error) {
+	if !identifierRegexp.MatchString(keyspace) {
+		return "", derrors.NewInvalidArgumentError("invalid keyspace name").WithParams(keyspace)
+	}
+	return fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", keyspace, replication.cql()), nil
+}
+
+// CreateKeyspace creates the keyspace of the ScyllaDB if it does not exist. It uses a session without keyspace, so
+// it can be called before Connect.
+func (s *ScyllaDB) CreateKeyspace(ctx context.Context, replication Replication) derrors.Error {
+	if s.Config != nil {
+		if err := s.Config.Validate(); err != nil {
+			return err
+		}
+	}
+	conf := s.ClusterConfig()
+	stmt, err := CreateKeyspaceStatement(conf.Keyspace, replication)
+	if err != nil {
+		return err
+	}
+	conf.Keyspace = ""
+	session, cqlErr := conf.CreateSession()
+	if cqlErr != nil {
+		return TranslateError(ctx, cqlErr, "cannot connect")
+	}
+	defer session.Close()
+	return execSchemaStatement(ctx, session, stmt)
+}
+
+// CreateTableStatements returns the statements that create the table of the metadata and the user defined types it
+// uses, if they do not exist. The types are created first.
+func CreateTableStatements(md *TableMetadata) ([]string, derrors.Error) {
+	types := &userTypes{names: make(map[string]reflect.Type, 0)}
+	columns := make([]string, 0, len(md.Columns))
+	for _, c := range md.Columns {
+		cqlType, err := types.cqlType(md.fields[c].Type, false)
+		if err != nil {
+			return nil, withParams(err, md.Table, c)
+		}
+		columns = append(columns, fmt.Sprintf("%s %s", c, cqlType))
+	}
+	primaryKey := "(" + strings.Join(md.PartitionKey, ", ") + ")"
+	if len(md.ClusteringKey) > 0 {
+		primaryKey = primaryKey + ", " + strings.Join(md.ClusteringKey, ", ")
+	}
+	columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", primaryKey))
+
+	return append(types.statements, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", md.Table, strings.Join(columns, ", "))), nil
+}
+
+// CreateTable creates the table of the metadata and the user defined types it uses, if they do not exist.
+func (s *ScyllaDB) CreateTable(ctx context.Context, md *TableMetadata) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+	statements, err := CreateTableStatements(md)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		if err := execSchemaStatement(ctx, session, stmt); err != nil {
+			return withParams(err, md.Table)
+		}
+	}
+	return nil
+}
+
+// userTypes contains the user defined types found while mapping the columns of a table.
+type userTypes struct {
+	// names contains the struct type of each user defined type.
+	names map[string]reflect.Type
+	// statements contains the statements that create the types, in dependency order.
+	statements []string
+}
+
+// cqlType returns the CQL type of a Go type. Collections and user defined types are frozen if required.
+func (u *userTypes) cqlType(t reflect.Type, frozen bool) (string, derrors.Error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return "timestamp", nil
+	case uuidType:
+		return "uuid", nil
+	case ipType:
+		return "inet", nil
+	case bigIntType:
+		return "varint", nil
+	case bytesType:
+		return "blob", nil
+	}
+
+	var result string
+	switch t.Kind() {
+	case reflect.String:
+		return "text", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8:
+		return "tinyint", nil
+	case reflect.Int16:
+		return "smallint", nil
+	case reflect.Int32:
+		return "int", nil
+	case reflect.Int, reflect.Int64:
+		return "bigint", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Float64:
+		return "double", nil
+	case reflect.Slice:
+		elem, err := u.cqlType(t.Elem(), true)
+		if err != nil {
+			return "", err
+		}
+		result = fmt.Sprintf("list<%s>", elem)
+	case reflect.Map:
+		key, err := u.cqlType(t.Key(), true)
+		if err != nil {
+			return "", err
+		}
+		value, err := u.cqlType(t.Elem(), true)
+		if err != nil {
+			return "", err
+		}
+		result = fmt.Sprintf("map<%s, %s>", key, value)
+	case reflect.Struct:
+		name, err := u.add(t)
+		if err != nil {
+			return "", err
+		}
+		// user defined types are always frozen, as gocql cannot update single fields
+		return fmt.Sprintf("frozen<%s>", name), nil
+	default:
+		return "", derrors.NewInvalidArgumentError("unsupported column type").WithParams(t.String())
+	}
+	if frozen {
+		return fmt.Sprintf("frozen<%s>", result), nil
+	}
+	return result, nil
+}
+
+// add adds the user defined type of a struct and the types it depends on, returning its name.
+func (u *userTypes) add(t reflect.Type) (string, derrors.Error) {
+	if t.Name() == "" {
+		return "", derrors.NewInvalidArgumentError("anonymous structs cannot be used as user defined types")
+	}
+	name := reflectx.CamelToSnakeASCII(t.Name())
+	if previous, exists := u.names[name]; exists {
+		if previous == nil {
+			// the type is still being processed, so it contains itself
+			return "", derrors.NewInvalidArgumentError("recursive user defined types are not supported").WithParams(t.String())
+		}
+		if previous != t {
+			return "", derrors.NewInvalidArgumentError("user defined type name used by several structs").WithParams(name, previous.String(), t.String())
+		}
+		return name, nil
+	}
+	// register the name before the fields to detect recursive types
+	u.names[name] = nil
+
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldName := strings.Split(field.Tag.Get("cql"), ",")[0]
+		if fieldName == "-" {
+			continue
+		}
+		if fieldName == "" {
+			return "", derrors.NewInvalidArgumentError("user defined type fields require a cql tag").WithParams(t.String(), field.Name)
+		}
+		fieldType, err := u.cqlType(field.Type, true)
+		if err != nil {
+			return "", withParams(err, t.String(), field.Name)
+		}
+		fields = append(fields, fmt.Sprintf("%s %s", fieldName, fieldType))
+	}
+	u.names[name] = t
+	u.statements = append(u.statements, fmt.Sprintf("CREATE TYPE IF NOT EXISTS %s (%s)", name, strings.Join(fields, ", ")))
+	return name, nil
+}
+
+// quoteString returns a CQL string literal.
+func quoteString(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"net"
+	"time"
+)
+
+const SchemaTable = "schematabletest"
+
+type SchemaAddress struct {
+	Street string   `cql:"street"`
+	Tags   []string `cql:"tags"`
+}
+
+type SchemaContact struct {
+	Name      string          `cql:"name"`
+	Addresses []SchemaAddress `cql:"addresses"`
+}
+
+type SchemaStruct struct {
+	OrganizationId gocql.UUID                `cql:"organization_id,pk"`
+	Region         string                    `cql:"region,pk"`
+	Created        time.Time                 `cql:"created,ck"`
+	Name           string                    `cql:"name"`
+	Enabled        bool                      `cql:"enabled"`
+	Replicas       int32                     `cql:"replicas"`
+	Size           int64                     `cql:"size"`
+	Ratio          *float64                  `cql:"ratio"`
+	Data           []byte                    `cql:"data"`
+	Ip             net.IP                    `cql:"ip"`
+	Labels         map[string]string         `cql:"labels"`
+	Ports          []int                     `cql:"ports"`
+	Groups         map[string][]string       `cql:"groups"`
+	Contact        SchemaContact             `cql:"contact"`
+	Contacts       map[string]*SchemaContact `cql:"contacts"`
+}
+
+type RecursiveStruct struct {
+	Children []RecursiveStruct `cql:"children"`
+}
+
+var _ = ginkgo.Describe("Schema", func() {
+
+	ginkgo.It("should create a keyspace with simple replication", func() {
+		stmt, err := CreateKeyspaceStatement("testkeyspace", SimpleReplication(3))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(stmt).Should(gomega.Equal("CREATE KEYSPACE IF NOT EXISTS testkeyspace WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 3}"))
+	})
+	ginkgo.It("should create a keyspace with network topology replication", func() {
+		stmt, err := CreateKeyspaceStatement("testkeyspace", NetworkTopologyReplication(map[string]int{"eu-west": 3, "us-east": 2}))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(stmt).Should(gomega.Equal("CREATE KEYSPACE IF NOT EXISTS testkeyspace WITH replication = {'class': 'NetworkTopologyStrategy', 'eu-west': 3, 'us-east': 2}"))
+	})
+	ginkgo.It("should reject invalid keyspace names", func() {
+		_, err := CreateKeyspaceStatement("test; DROP KEYSPACE other", SimpleReplication(1))
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+	})
+	ginkgo.It("should create a table from a tagged struct", func() {
+		md, err := NewTableMetadata(SchemaTable, &SchemaStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+		statements, err := CreateTableStatements(md)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(statements).Should(gomega.Equal([]string{
+			"CREATE TYPE IF NOT EXISTS schema_address (street text, tags frozen<list<text>>)",
+			"CREATE TYPE IF NOT EXISTS schema_contact (name text, addresses frozen<list<frozen<schema_address>>>)",
+			"CREATE TABLE IF NOT EXISTS schematabletest (organization_id uuid, region text, created timestamp, name text, " +
+				"enabled boolean, replicas int, size bigint, ratio double, data blob, ip inet, labels map<text, text>, " +
+				"ports list<bigint>, groups map<text, frozen<list<text>>>, contact frozen<schema_contact>, " +
+				"contacts map<text, frozen<schema_contact>>, PRIMARY KEY ((organization_id, region), created))",
+		}))
+	})
+	ginkgo.It("should reject unsupported types", func() {
+		type UnsupportedStruct struct {
+			Id    string `cql:"id,pk"`
+			Count uint64 `cql:"count"`
+		}
+		md, err := NewTableMetadata("unsupported", &UnsupportedStruct{})
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = CreateTableStatements(md)
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+	})
+	ginkgo.It("should reject recursive types", func() {
+		type RecursiveTable struct {
+			Id   string          `cql:"id,pk"`
+			Tree RecursiveStruct `cql:"tree"`
+		}
+		md, err := NewTableMetadata("recursive", &RecursiveTable{})
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = CreateTableStatements(md)
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+	})
+})
@@ -18,73 +18,146 @@
 package scylladb
 
 import (
+	"context"
 	"fmt"
 	"github.com/gocql/gocql"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/rs/zerolog/log"
+	"github.com/scylladb/go-reflectx"
 	"github.com/scylladb/gocqlx"
 	"github.com/scylladb/gocqlx/qb"
+	"sync"
 )
 
-// NOTE: UnsafeList does not exist because we need to know the struct where the data will be loaded
-//       to unmarshall the result.
-
 // RowNotFoundMsg corresponds to the error message returned by ScyllaDB if the row is not found.
+//
+// Deprecated: use IsNotFound, that does not depend on the message of the error.
 const RowNotFoundMsg = "not found"
 
 // General purpose structure to be reused to build ScyllaDB providers on top sharing common functionality.
+// The gocql session is safe for concurrent use, and its lifecycle is protected by the ScyllaDB itself, so
+// the functions can be called from several goroutines without any additional lock. Session must not be
+// modified directly once the ScyllaDB is in use.
 type ScyllaDB struct {
 	Address  string
 	Port     int
 	Keyspace string
-	Session  *gocql.Session
+	// Config contains the full configuration of the connection. If set, Address, Port and Keyspace are ignored.
+	Config *Config
+	// ReconnectPolicy contains the parameters of the reconnection when the session is not created. If nil, a
+	// single attempt is made.
+	ReconnectPolicy *ReconnectPolicy
+	// RetryPolicy contains the parameters of the retries of the queries of the CRUD functions. If nil, the queries
+	// are not retried.
+	RetryPolicy *RetryPolicy
+	// RetryPolicies overrides the RetryPolicy of some operations.
+	RetryPolicies map[Operation]*RetryPolicy
+	Session       *gocql.Session
+	// sessionMutex protects Session and hosts. Queries only hold it while the session is retrieved.
+	sessionMutex sync.RWMutex
+	// hosts records the state of the hosts of the session.
+	hosts *hostTracker
+	// reconnectMutex protects reconnection.
+	reconnectMutex sync.Mutex
+	reconnection   reconnection
 }
 
 // Connect to the ScyllaDB .
 func (s *ScyllaDB) Connect() derrors.Error {
-	// connect to the cluster
-	conf := gocql.NewCluster(s.Address)
-	conf.Keyspace = s.Keyspace
-	conf.Port = s.Port
+	return s.ConnectContext(context.Background())
+}
 
-	session, err := conf.CreateSession()
+// ConnectContext connects to the ScyllaDB giving up when the context is done. In that case, the session is closed as
+// soon as it is created.
+func (s *ScyllaDB) ConnectContext(ctx context.Context) derrors.Error {
+	session, hosts, err := s.connect(ctx)
 	if err != nil {
-		log.Error().Str("trace", conversions.ToDerror(err).DebugReport()).Msg("unable to connect")
-		return derrors.AsError(err, "cannot connect")
+		return err
 	}
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
 	s.Session = session
+	s.hosts = hosts
 	return nil
 }
 
+// connect creates a new session without installing it, so sessionMutex is not held while the cluster is contacted.
+func (s *ScyllaDB) connect(ctx context.Context) (*gocql.Session, *hostTracker, derrors.Error) {
+	if s.Config != nil {
+		if err := s.Config.Validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// connect to the cluster
+	conf := s.ClusterConfig()
+	hosts := newHostTracker(conf.PoolConfig.HostSelectionPolicy)
+	conf.PoolConfig.HostSelectionPolicy = hosts
+
+	type createResult struct {
+		session *gocql.Session
+		err     error
+	}
+	created := make(chan createResult, 1)
+	go func() {
+		session, err := conf.CreateSession()
+		created <- createResult{session: session, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		go func() {
+			if result := <-created; result.session != nil {
+				result.session.Close()
+			}
+		}()
+		log.Error().Str("err", ctx.Err().Error()).Msg("unable to connect")
+		return nil, nil, TranslateError(ctx, ctx.Err(), "cannot connect")
+	case result := <-created:
+		if result.err != nil {
+			log.Error().Str("trace", conversions.ToDerror(result.err).DebugReport()).Msg("unable to connect")
+			return nil, nil, TranslateError(ctx, result.err, "cannot connect")
+		}
+		return result.session, hosts, nil
+	}
+}
+
 // Disconnect from the database
 func (s *ScyllaDB) Disconnect() {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
 	if s.Session != nil {
 		s.Session.Close()
 		s.Session = nil
+		s.hosts = nil
 	}
 }
 
-// CheckConnection checks that the session is created
+// CheckConnection checks that the session is created. Use HealthCheck to check that the cluster answers queries.
 func (s *ScyllaDB) CheckConnection() derrors.Error {
+	s.sessionMutex.RLock()
+	defer s.sessionMutex.RUnlock()
 	if s.Session == nil {
 		return derrors.NewGenericError("Session not created")
 	}
 	return nil
 }
 
-// CheckAndConnect checks if the connection is set and tries to reconnect otherwise.
+// CheckAndConnect checks if the connection is set and tries to reconnect otherwise following the ReconnectPolicy.
 func (s *ScyllaDB) CheckAndConnect() derrors.Error {
-	err := s.CheckConnection()
-	if err != nil {
-		log.Info().Msg("session no created, trying to reconnect...")
-		// try to reconnect
-		err = s.Connect()
-		if err != nil {
-			return err
-		}
+	_, err := s.getSession(context.Background())
+	return err
+}
+
+// getSession returns the current session, trying to reconnect if it is not created. Only one goroutine
+// reconnects, the rest wait for it or fail fast as defined in the ReconnectPolicy. The reconnection and the wait
+// stop when the context is done.
+func (s *ScyllaDB) getSession(ctx context.Context) (*gocql.Session, derrors.Error) {
+	if session := s.currentSession(); session != nil {
+		return session, nil
 	}
-	return nil
+	return s.reconnect(ctx)
 }
 
 // ----------------------------------------------------------------
@@ -93,21 +166,27 @@ func (s *ScyllaDB) CheckAndConnect() derrors.Error {
 
 // UnsafeGenericExist checks if an element identified by a single primary key exists.
 func (s *ScyllaDB) UnsafeGenericExist(table string, pkColumn string, pkValue string) (bool, derrors.Error) {
+	return s.UnsafeGenericExistContext(context.Background(), table, pkColumn, pkValue)
+}
+
+// UnsafeGenericExistContext is the context-aware version of UnsafeGenericExist. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeGenericExistContext(ctx context.Context, table string, pkColumn string, pkValue string) (bool, derrors.Error) {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return false, err
 	}
 	var count int
 
 	stmt, names := qb.Select(table).CountAll().Where(qb.Eq(pkColumn)).ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindMap(qb.M{pkColumn: pkValue})
-
-	err := q.GetRelease(&count)
+	err = s.execute(ctx, session, ExistOperation, stmt, "cannot determinate if elements exists", func(q *gocql.Query) error {
+		return gocqlx.Query(q, names).BindMap(qb.M{pkColumn: pkValue}).GetRelease(&count)
+	})
 	if err != nil {
-		if err.Error() == RowNotFoundMsg {
+		if IsNotFound(err) {
 			return false, nil
 		} else {
-			return false, derrors.AsError(err, "cannot determinate if elements exists")
+			return false, err
 		}
 	}
 
@@ -115,12 +194,22 @@ func (s *ScyllaDB) UnsafeGenericExist(table string, pkColumn string, pkValue str
 }
 
 // UnsafeAdd adds a new element to a table identified by a single primary key.
-func (s *ScyllaDB) UnsafeAdd(table string, pkColumn string, pkValue string, tableColumnNames []string, toAdd interface{}) derrors.Error {
+func (s *ScyllaDB) UnsafeAdd(table string, pkColumn string, pkValue string, tableColumnNames []string, toAdd interface{}, options ...WriteOption) derrors.Error {
+	return s.UnsafeAddContext(context.Background(), table, pkColumn, pkValue, tableColumnNames, toAdd, options...)
+}
+
+// UnsafeAddContext is the context-aware version of UnsafeAdd. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeAddContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string, toAdd interface{}, options ...WriteOption) derrors.Error {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+	writeOptions, err := newWriteOptions(options)
+	if err != nil {
 		return err
 	}
-	exists, err := s.UnsafeGenericExist(table, pkColumn, pkValue)
+	exists, err := s.UnsafeGenericExistContext(ctx, table, pkColumn, pkValue)
 	if err != nil {
 		return err
 	}
@@ -129,25 +218,36 @@ func (s *ScyllaDB) UnsafeAdd(table string, pkColumn string, pkValue string, tabl
 	}
 
 	// insert the instance
-	stmt, names := qb.Insert(table).Columns(tableColumnNames...).ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindStruct(toAdd)
-	cqlErr := q.ExecRelease()
+	stmt, names := writeOptions.insert(qb.Insert(table).Columns(tableColumnNames...)).ToCql()
+	err = s.execute(ctx, session, AddOperation, stmt, "cannot add new element", func(q *gocql.Query) error {
+		return gocqlx.Query(q, names).BindStructMap(toAdd, writeOptions.values()).ExecRelease()
+	})
 
-	if cqlErr != nil {
-		log.Warn().Str("err", cqlErr.Error()).Msg("error adding the element")
-		return derrors.AsError(cqlErr, "cannot add new element")
+	if err != nil {
+		log.Warn().Str("err", err.Error()).Msg("error adding the element")
+		return err
 	}
 
 	return nil
 }
 
 // UnsafeUpdate updates an element in a table identified by a single primary key.
-func (s *ScyllaDB) UnsafeUpdate(table string, pkColumn string, pkValue string, tableColumnNames []string, toUpdate interface{}) derrors.Error {
+func (s *ScyllaDB) UnsafeUpdate(table string, pkColumn string, pkValue string, tableColumnNames []string, toUpdate interface{}, options ...WriteOption) derrors.Error {
+	return s.UnsafeUpdateContext(context.Background(), table, pkColumn, pkValue, tableColumnNames, toUpdate, options...)
+}
+
+// UnsafeUpdateContext is the context-aware version of UnsafeUpdate. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeUpdateContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string, toUpdate interface{}, options ...WriteOption) derrors.Error {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return err
 	}
-	exists, err := s.UnsafeGenericExist(table, pkColumn, pkValue)
+	writeOptions, err := newWriteOptions(options)
+	if err != nil {
+		return err
+	}
+	exists, err := s.UnsafeGenericExistContext(ctx, table, pkColumn, pkValue)
 	if err != nil {
 		return err
 	}
@@ -156,12 +256,13 @@ func (s *ScyllaDB) UnsafeUpdate(table string, pkColumn string, pkValue string, t
 	}
 
 	// update the instance
-	stmt, names := qb.Update(table).Set(tableColumnNames...).Where(qb.Eq(pkColumn)).ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindStruct(toUpdate)
-	cqlErr := q.ExecRelease()
+	stmt, names := writeOptions.update(qb.Update(table).Set(tableColumnNames...)).Where(qb.Eq(pkColumn)).ToCql()
+	err = s.execute(ctx, session, UpdateOperation, stmt, "cannot update element", func(q *gocql.Query) error {
+		return gocqlx.Query(q, names).BindStructMap(toUpdate, writeOptions.values()).ExecRelease()
+	})
 
-	if cqlErr != nil {
-		return derrors.AsError(cqlErr, "cannot update element")
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -169,20 +270,26 @@ func (s *ScyllaDB) UnsafeUpdate(table string, pkColumn string, pkValue string, t
 
 // UnsafeGet retrieves an element from a table identified by a single primary key.
 func (s *ScyllaDB) UnsafeGet(table string, pkColumn string, pkValue string, tableColumnNames []string, result *interface{}) derrors.Error {
+	return s.UnsafeGetContext(context.Background(), table, pkColumn, pkValue, tableColumnNames, result)
+}
+
+// UnsafeGetContext is the context-aware version of UnsafeGet. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeGetContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string, result *interface{}) derrors.Error {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return err
 	}
 
 	stmt, names := qb.Select(table).Columns(tableColumnNames...).Where(qb.Eq(pkColumn)).ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindMap(qb.M{pkColumn: pkValue})
-
-	err := q.GetRelease(*result)
+	err = s.execute(ctx, session, GetOperation, stmt, "cannot get element", func(q *gocql.Query) error {
+		return gocqlx.Query(q, names).BindMap(qb.M{pkColumn: pkValue}).GetRelease(*result)
+	})
 	if err != nil {
-		if err.Error() == RowNotFoundMsg {
+		if IsNotFound(err) {
 			return derrors.NewNotFoundError(table).WithParams(pkValue)
 		} else {
-			return derrors.AsError(err, "cannot get element")
+			return err
 		}
 	}
 
@@ -191,12 +298,18 @@ func (s *ScyllaDB) UnsafeGet(table string, pkColumn string, pkValue string, tabl
 
 // UnsafeRemove removes an element from a table identified by a single primary key.
 func (s *ScyllaDB) UnsafeRemove(table string, pkColumn string, pkValue string) derrors.Error {
-	if err := s.CheckAndConnect(); err != nil {
+	return s.UnsafeRemoveContext(context.Background(), table, pkColumn, pkValue)
+}
+
+// UnsafeRemoveContext is the context-aware version of UnsafeRemove. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeRemoveContext(ctx context.Context, table string, pkColumn string, pkValue string) derrors.Error {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return err
 	}
 
 	// check if the asset exists
-	exists, err := s.UnsafeGenericExist(table, pkColumn, pkValue)
+	exists, err := s.UnsafeGenericExistContext(ctx, table, pkColumn, pkValue)
 	if err != nil {
 		return err
 	}
@@ -206,10 +319,12 @@ func (s *ScyllaDB) UnsafeRemove(table string, pkColumn string, pkValue string) d
 
 	// delete instance
 	stmt, _ := qb.Delete(table).Where(qb.Eq(pkColumn)).ToCql()
-	cqlErr := s.Session.Query(stmt, pkValue).Exec()
+	err = s.execute(ctx, session, RemoveOperation, stmt, "cannot remove element", func(q *gocql.Query) error {
+		return q.Bind(pkValue).Exec()
+	})
 
-	if cqlErr != nil {
-		return derrors.AsError(cqlErr, "cannot remove element")
+	if err != nil {
+		return err
 	}
 	return nil
 }
@@ -220,18 +335,24 @@ func (s *ScyllaDB) UnsafeRemove(table string, pkColumn string, pkValue string) d
 
 // UnsafeClear truncates a set of tables.
 func (s *ScyllaDB) UnsafeClear(tableNames []string) derrors.Error {
+	return s.UnsafeClearContext(context.Background(), tableNames)
+}
+
+// UnsafeClearContext is the context-aware version of UnsafeClear. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeClearContext(ctx context.Context, tableNames []string) derrors.Error {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return err
 	}
 
 	for _, targetTable := range tableNames {
 		query := fmt.Sprintf("TRUNCATE TABLE %s", targetTable)
 		// delete table
-		err := s.Session.Query(query).Exec()
+		err := session.Query(query).WithContext(ctx).Exec()
 		if err != nil {
 			log.Error().Str("trace", conversions.ToDerror(err).DebugReport()).Str("table", targetTable).Msg("failed to truncate table")
-			return derrors.AsError(err, "cannot truncate table")
+			return TranslateError(ctx, err, "cannot truncate table")
 		}
 	}
 	return nil
@@ -243,8 +364,14 @@ func (s *ScyllaDB) UnsafeClear(tableNames []string) derrors.Error {
 
 // UnsafeGenericExist checks if an element identified by a composite primary key exists.
 func (s *ScyllaDB) UnsafeGenericCompositeExist(table string, pkColumn map[string]interface{}) (bool, derrors.Error) {
+	return s.UnsafeGenericCompositeExistContext(context.Background(), table, pkColumn)
+}
+
+// UnsafeGenericCompositeExistContext is the context-aware version of UnsafeGenericCompositeExist. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeGenericCompositeExistContext(ctx context.Context, table string, pkColumn map[string]interface{}) (bool, derrors.Error) {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return false, err
 	}
 
@@ -256,14 +383,14 @@ func (s *ScyllaDB) UnsafeGenericCompositeExist(table string, pkColumn map[string
 	}
 
 	stmt, names := sb.ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindMap(pkColumn)
-
-	err := q.GetRelease(&count)
+	err = s.execute(ctx, session, ExistOperation, stmt, "cannot determinate if elements exists", func(q *gocql.Query) error {
+		return gocqlx.Query(q, names).BindMap(pkColumn).GetRelease(&count)
+	})
 	if err != nil {
-		if err.Error() == RowNotFoundMsg {
+		if IsNotFound(err) {
 			return false, nil
 		} else {
-			return false, derrors.AsError(err, "cannot determinate if elements exists")
+			return false, err
 		}
 	}
 
@@ -271,12 +398,27 @@ func (s *ScyllaDB) UnsafeGenericCompositeExist(table string, pkColumn map[string
 }
 
 // UnsafeAdd adds a new element to a table identified by a composite primary key.
-func (s *ScyllaDB) UnsafeCompositeAdd(table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}) derrors.Error {
+func (s *ScyllaDB) UnsafeCompositeAdd(table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}, options ...WriteOption) derrors.Error {
+	return s.UnsafeCompositeAddContext(context.Background(), table, pkColumn, tableColumnNames, toAdd, options...)
+}
+
+// UnsafeCompositeAddContext is the context-aware version of UnsafeCompositeAdd. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeAddContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}, options ...WriteOption) derrors.Error {
+	return s.compositeAdd(ctx, gocqlx.DefaultMapper, table, pkColumn, tableColumnNames, toAdd, options)
+}
+
+// compositeAdd adds a new element, binding the columns from the fields of toAdd resolved by the mapper.
+func (s *ScyllaDB) compositeAdd(ctx context.Context, mapper *reflectx.Mapper, table string, pkColumn map[string]interface{}, tableColumnNames []string, toAdd interface{}, options []WriteOption) derrors.Error {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+	writeOptions, err := newWriteOptions(options)
+	if err != nil {
 		return err
 	}
-	exists, err := s.UnsafeGenericCompositeExist(table, pkColumn)
+	exists, err := s.UnsafeGenericCompositeExistContext(ctx, table, pkColumn)
 	if err != nil {
 		return err
 	}
@@ -285,24 +427,40 @@ func (s *ScyllaDB) UnsafeCompositeAdd(table string, pkColumn map[string]interfac
 	}
 
 	// insert the instance
-	stmt, names := qb.Insert(table).Columns(tableColumnNames...).ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindStruct(toAdd)
-	cqlErr := q.ExecRelease()
+	stmt, names := writeOptions.insert(qb.Insert(table).Columns(tableColumnNames...)).ToCql()
+	err = s.execute(ctx, session, AddOperation, stmt, "cannot add new element", func(q *gocql.Query) error {
+		return bindQuery(q, names, mapper).BindStructMap(toAdd, writeOptions.values()).ExecRelease()
+	})
 
-	if cqlErr != nil {
-		return derrors.AsError(cqlErr, "cannot add new element")
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
 // UnsafeUpdate updates an element in a table identified by a single primary key.
-func (s *ScyllaDB) UnsafeCompositeUpdate(table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}) derrors.Error {
+func (s *ScyllaDB) UnsafeCompositeUpdate(table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}, options ...WriteOption) derrors.Error {
+	return s.UnsafeCompositeUpdateContext(context.Background(), table, pkColumn, tableColumnNames, toUpdate, options...)
+}
+
+// UnsafeCompositeUpdateContext is the context-aware version of UnsafeCompositeUpdate. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeUpdateContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}, options ...WriteOption) derrors.Error {
+	return s.compositeUpdate(ctx, gocqlx.DefaultMapper, table, pkColumn, tableColumnNames, toUpdate, options)
+}
+
+// compositeUpdate updates an element, binding the columns from the fields of toUpdate resolved by the mapper.
+func (s *ScyllaDB) compositeUpdate(ctx context.Context, mapper *reflectx.Mapper, table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpdate interface{}, options []WriteOption) derrors.Error {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+	writeOptions, err := newWriteOptions(options)
+	if err != nil {
 		return err
 	}
-	exists, err := s.UnsafeGenericCompositeExist(table, pkColumn)
+	exists, err := s.UnsafeGenericCompositeExistContext(ctx, table, pkColumn)
 	if err != nil {
 		return err
 	}
@@ -310,17 +468,18 @@ func (s *ScyllaDB) UnsafeCompositeUpdate(table string, pkColumn map[string]inter
 		return derrors.NewNotFoundError(table).WithParams(getParams(pkColumn))
 	}
 
-	sb := qb.Update(table).Set(tableColumnNames...)
+	sb := writeOptions.update(qb.Update(table).Set(tableColumnNames...))
 	for p := range pkColumn {
 		sb = sb.Where(qb.Eq(p))
 	}
 
 	stmt, names := sb.ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindStruct(toUpdate)
-	cqlErr := q.ExecRelease()
+	err = s.execute(ctx, session, UpdateOperation, stmt, "cannot update element", func(q *gocql.Query) error {
+		return bindQuery(q, names, mapper).BindStructMap(toUpdate, writeOptions.values()).ExecRelease()
+	})
 
-	if cqlErr != nil {
-		return derrors.AsError(cqlErr, "cannot update element")
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -328,24 +487,43 @@ func (s *ScyllaDB) UnsafeCompositeUpdate(table string, pkColumn map[string]inter
 
 // UnsafeGet retrieves an element from a table identified by a composite primary key.
 func (s *ScyllaDB) UnsafeCompositeGet(table string, pkColumn map[string]interface{}, tableColumnNames []string, result *interface{}) derrors.Error {
+	return s.UnsafeCompositeGetContext(context.Background(), table, pkColumn, tableColumnNames, result)
+}
+
+// UnsafeCompositeGetContext is the context-aware version of UnsafeCompositeGet. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeGetContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string, result *interface{}) derrors.Error {
+	return s.compositeGet(ctx, gocqlx.DefaultMapper, table, pkColumn, tableColumnNames, *result)
+}
+
+// compositeGet retrieves an element, loading the columns into the fields of result resolved by the mapper. Only the
+// given columns are selected, so the columns of the table that result does not map are not read.
+func (s *ScyllaDB) compositeGet(ctx context.Context, mapper *reflectx.Mapper, table string, pkColumn map[string]interface{}, tableColumnNames []string, result interface{}) derrors.Error {
 	// check connection
-	if err := s.CheckAndConnect(); err != nil {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return err
 	}
 
-	sb := qb.Select(table)
+	sb := qb.Select(table).Columns(tableColumnNames...)
 	for p := range pkColumn {
 		sb = sb.Where(qb.Eq(p))
 	}
 	stmt, names := sb.ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindMap(pkColumn)
-
-	err := q.GetRelease(*result)
+	err = s.execute(ctx, session, GetOperation, stmt, "cannot get element", func(q *gocql.Query) error {
+		qx := gocqlx.Query(q, names).BindMap(pkColumn)
+		defer qx.Release()
+		if qx.Err() != nil {
+			return qx.Err()
+		}
+		iter := gocqlx.Iter(qx.Query)
+		iter.Mapper = mapper
+		return iter.Get(result)
+	})
 	if err != nil {
-		if err.Error() == RowNotFoundMsg {
+		if IsNotFound(err) {
 			return derrors.NewNotFoundError(table).WithParams(getParams(pkColumn))
 		} else {
-			return derrors.AsError(err, "cannot get element")
+			return err
 		}
 	}
 
@@ -354,12 +532,18 @@ func (s *ScyllaDB) UnsafeCompositeGet(table string, pkColumn map[string]interfac
 
 // UnsafeRemove removes an element from a table identified by a single primary key.
 func (s *ScyllaDB) UnsafeCompositeRemove(table string, pkColumn map[string]interface{}) derrors.Error {
-	if err := s.CheckAndConnect(); err != nil {
+	return s.UnsafeCompositeRemoveContext(context.Background(), table, pkColumn)
+}
+
+// UnsafeCompositeRemoveContext is the context-aware version of UnsafeCompositeRemove. The context is passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeRemoveContext(ctx context.Context, table string, pkColumn map[string]interface{}) derrors.Error {
+	session, err := s.getSession(ctx)
+	if err != nil {
 		return err
 	}
 
 	// check if the asset exists
-	exists, err := s.UnsafeGenericCompositeExist(table, pkColumn)
+	exists, err := s.UnsafeGenericCompositeExistContext(ctx, table, pkColumn)
 	if err != nil {
 		return err
 	}
@@ -374,16 +558,23 @@ func (s *ScyllaDB) UnsafeCompositeRemove(table string, pkColumn map[string]inter
 	}
 
 	stmt, names := sb.ToCql()
-	q := gocqlx.Query(s.Session.Query(stmt), names).BindMap(pkColumn)
+	err = s.execute(ctx, session, RemoveOperation, stmt, "cannot remove element", func(q *gocql.Query) error {
+		return gocqlx.Query(q, names).BindMap(pkColumn).ExecRelease()
+	})
 
-	cqlErr := q.Exec()
-
-	if cqlErr != nil {
-		return derrors.AsError(cqlErr, "cannot remove element")
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
+// bindQuery wraps a query to bind its named parameters with the given mapper.
+func bindQuery(q *gocql.Query, names []string, mapper *reflectx.Mapper) *gocqlx.Queryx {
+	qx := gocqlx.Query(q, names)
+	qx.Mapper = mapper
+	return qx
+}
+
 func getParams(pkColumn map[string]interface{}) []interface{} {
 	params := make([]interface{}, 0)
 	for _, p := range pkColumn {
@@ -18,28 +18,31 @@
 /*
  - ENVIRONMENT VARIABLES:
  RUN_INTEGRATION_TEST=true
- IT_SCYLLA_HOST=127.0.0.1
+ IT_SCYLLA_HOSTS=127.0.0.1
  IT_SCYLLA_PORT=9042
- IT_NALEJ_KEYSPACE=testkeyspace
+ IT_SCYLLA_KEYSPACE=testkeyspace
+ The former IT_SCYLLA_HOST and IT_NALEJ_KEYSPACE variables are still read if the new ones are not set.
 
  - commands to execute:
  docker run --name scylla -p 9042:9042 -d scylladb/scylla
  docker exec -it scylla cqlsh
- create KEYSPACE testkeyspace WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1};
- use testkeyspace;
- create table testkeyspace.tableTest (id1 text, id2 text, id3 text, primary key (id1, id2));
- create table testkeyspace.basicTableTest (id1 text, id2 text, id3 text, primary key (id1));
+ The keyspace is created with a SimpleStrategy replication and the tables are created by the migrations of
+ testdata/migrations.
 */
 package scylladb
 
 import (
+	"context"
+	"github.com/gocql/gocql"
 	"github.com/google/uuid"
+	"github.com/nalej/derrors"
 	"github.com/nalej/scylladb-utils/pkg/utils"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
 	"github.com/rs/zerolog/log"
 	"os"
-	"strconv"
+	"sync"
+	"time"
 )
 
 var _ = ginkgo.Describe("Scylla cluster provider", func() {
@@ -49,32 +52,120 @@ var _ = ginkgo.Describe("Scylla cluster provider", func() {
 		return
 	}
 
-	var scyllaHost = os.Getenv("IT_SCYLLA_HOST")
-	if scyllaHost == "" {
-		ginkgo.Fail("missing environment variables")
-	}
-	var nalejKeySpace = os.Getenv("IT_NALEJ_KEYSPACE")
-	if scyllaHost == "" {
-		ginkgo.Fail("missing environment variables")
-	}
-	scyllaPort, _ := strconv.Atoi(os.Getenv("IT_SCYLLA_PORT"))
-	if scyllaPort <= 0 {
-		ginkgo.Fail("missing environment variables")
+	config, err := integrationTestConfig()
+	if err != nil {
+		ginkgo.Fail(err.Error())
 	}
 
 	// create a provider and connect it
-	sp := NewScyllaDBProvider(scyllaHost, scyllaPort, nalejKeySpace)
+	sp := &ScyllaDB{Config: config}
 
 	ginkgo.BeforeSuite(func() {
+		kError := sp.CreateKeyspace(context.Background(), SimpleReplication(1))
+		gomega.Expect(kError).To(gomega.Succeed())
 		cError := sp.Connect()
 		gomega.Expect(cError).To(gomega.Succeed())
+		_, mError := sp.MigrateFS(context.Background(), os.DirFS("testdata/migrations"), MigrateOptions{})
+		gomega.Expect(mError).To(gomega.Succeed())
 	})
 
 	ginkgo.AfterSuite(func() {
-		sp.UnsafeClear([]string{Table, BasicTable})
+		sp.UnsafeClear([]string{Table, BasicTable, VersionedTable, SchemaTable, MaskedTable})
 		sp.Disconnect()
 	})
 
+	ginkgo.Context("Schema", func() {
+		ginkgo.It("Should create a table from a struct", func() {
+			md, err := NewTableMetadata(SchemaTable, &SchemaStruct{})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = sp.CreateTable(context.Background(), md)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			ratio := 0.5
+			entity := &SchemaStruct{
+				OrganizationId: gocql.TimeUUID(),
+				Region:         "eu-west",
+				Created:        time.Now().UTC().Truncate(time.Millisecond),
+				Name:           "name",
+				Ratio:          &ratio,
+				Labels:         map[string]string{"key": "value"},
+				Contact:        SchemaContact{Name: "contact", Addresses: []SchemaAddress{{Street: "street", Tags: []string{"tag"}}}},
+			}
+			err = sp.UnsafeEntityAdd(md, entity)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			retrieved := &SchemaStruct{OrganizationId: entity.OrganizationId, Region: entity.Region, Created: entity.Created}
+			err = sp.UnsafeEntityGet(md, retrieved)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved.Name).Should(gomega.Equal(entity.Name))
+			gomega.Expect(*retrieved.Ratio).Should(gomega.Equal(ratio))
+			gomega.Expect(retrieved.Labels).Should(gomega.Equal(entity.Labels))
+			gomega.Expect(retrieved.Contact).Should(gomega.Equal(entity.Contact))
+		})
+		ginkgo.It("Should validate the schema of a table", func() {
+			md, err := NewTableMetadata(SchemaTable, &SchemaStruct{})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = sp.CreateTable(context.Background(), md)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			drift, err := sp.ValidateTable(md)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(drift.Error()).Should(gomega.BeNil())
+
+			drift, err = sp.ValidateColumns(Table, append(AllTableColumns, "missing"))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(drift.MissingColumns).Should(gomega.Equal([]string{"missing"}))
+		})
+	})
+
+	ginkgo.Context("Migrations", func() {
+		ginkgo.It("Should not apply the migrations twice", func() {
+			applied, err := sp.MigrateFS(context.Background(), os.DirFS("testdata/migrations"), MigrateOptions{})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(applied).Should(gomega.BeEmpty())
+		})
+		ginkgo.It("Should refuse to apply modified migrations", func() {
+			migrations, err := LoadMigrations(os.DirFS("testdata/migrations"))
+			gomega.Expect(err).To(gomega.Succeed())
+			migrations[0].Checksum = "modified"
+			_, err = sp.Migrate(context.Background(), migrations, MigrateOptions{})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("Should not apply the migrations claimed by another process", func() {
+			options := MigrateOptions{Table: "claimed_migrations"}
+			_, err := sp.Migrate(context.Background(), nil, options)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			session, err := sp.getSession(context.Background())
+			gomega.Expect(err).To(gomega.Succeed())
+			migration := Migration{Version: 1, Description: "claimed", Checksum: uuid.New().String()}
+			err = claimMigration(context.Background(), session, options.Table, migration)
+			gomega.Expect(err).To(gomega.Succeed())
+			err = claimMigration(context.Background(), session, options.Table, migration)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.Aborted))
+
+			applied, err := sp.Migrate(context.Background(), []Migration{migration}, options)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.Aborted))
+			gomega.Expect(applied).Should(gomega.BeEmpty())
+		})
+	})
+
+	ginkgo.Context("Health", func() {
+		ginkgo.It("Should report a healthy cluster", func() {
+			status := sp.HealthCheck(context.Background())
+			gomega.Expect(status.Error).Should(gomega.BeNil())
+			gomega.Expect(status.Healthy).Should(gomega.BeTrue())
+			gomega.Expect(status.ServingStatus()).Should(gomega.Equal(ServingStatus))
+			gomega.Expect(status.ReleaseVersion).ShouldNot(gomega.BeEmpty())
+			gomega.Expect(status.Hosts).ShouldNot(gomega.BeEmpty())
+			for _, h := range status.Hosts {
+				gomega.Expect(h.Up).Should(gomega.BeTrue())
+			}
+		})
+	})
+
 	ginkgo.Context("Simple Test", func() {
 		ginkgo.It("Should be able to add a register", func() {
 			compo := GetCompositeStruct()
@@ -143,6 +234,29 @@ var _ = ginkgo.Describe("Scylla cluster provider", func() {
 			err := sp.UnsafeUpdate(BasicTable, pk, val, AllTableColumnsNoPK, compo)
 			gomega.Expect(err).NotTo(gomega.Succeed())
 		})
+		ginkgo.It("should be able to add a register with TTL and timestamp", func() {
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+			timestamp := time.Now().Add(-time.Minute).Truncate(time.Microsecond)
+
+			err := sp.UnsafeAdd(BasicTable, pk, val, AllTableColumns, compo, WithTTL(time.Hour), WithTimestamp(timestamp))
+			gomega.Expect(err).To(gomega.Succeed())
+
+			metadata, err := sp.UnsafeWriteMetadata(BasicTable, pk, val, AllTableColumnsNoPK)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(metadata["id3"].TTL).Should(gomega.BeNumerically("~", time.Hour, time.Minute))
+			gomega.Expect(metadata["id3"].WriteTime.Equal(timestamp)).Should(gomega.BeTrue())
+
+			// an older write does not overwrite the columns
+			updated := *compo
+			updated.Id3 = uuid.New().String()
+			err = sp.UnsafeUpdate(BasicTable, pk, val, AllTableColumnsNoPK, updated, WithTimestamp(timestamp.Add(-time.Second)))
+			gomega.Expect(err).To(gomega.Succeed())
+			var retrieved interface{} = &CompositeStruct{}
+			err = sp.UnsafeGet(BasicTable, pk, val, AllTableColumns, &retrieved)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(compo))
+		})
 		ginkgo.It("should be able to delete a register", func() {
 			compo := GetCompositeStruct()
 			pk, val := GetValues(*compo)
@@ -160,6 +274,92 @@ var _ = ginkgo.Describe("Scylla cluster provider", func() {
 			err := sp.UnsafeRemove(BasicTable, pk, val)
 			gomega.Expect(err).NotTo(gomega.Succeed())
 		})
+		ginkgo.It("should be able to add a register if not exists", func() {
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+
+			err := sp.UnsafeAddIfNotExists(BasicTable, pk, val, AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.UnsafeAddIfNotExists(BasicTable, pk, val, AllTableColumns, compo)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should be able to update a register if exists", func() {
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+
+			err := sp.UnsafeUpdateIfExists(BasicTable, pk, val, AllTableColumnsNoPK, compo)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			err = sp.UnsafeAdd(BasicTable, pk, val, AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			compo.Id3 = uuid.New().String()
+			err = sp.UnsafeUpdateIfExists(BasicTable, pk, val, AllTableColumnsNoPK, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		ginkgo.It("should not be able to update a register if the condition fails", func() {
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+
+			err := sp.UnsafeAdd(BasicTable, pk, val, AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			previous := compo.Id3
+			compo.Id3 = uuid.New().String()
+			err = sp.UnsafeUpdateIf(BasicTable, pk, val, AllTableColumnsNoPK, compo, map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			err = sp.UnsafeUpdateIf(BasicTable, pk, val, AllTableColumnsNoPK, compo, map[string]interface{}{"id3": previous})
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		ginkgo.It("should not find a non exists register with conditions", func() {
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+
+			err := sp.UnsafeUpdateIf(BasicTable, pk, val, AllTableColumnsNoPK, compo, map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NotFound))
+
+			err = sp.UnsafeRemoveIf(BasicTable, pk, val, map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NotFound))
+
+			err = sp.UnsafeCompositeUpdateIf(Table, GetCompositeValues(*compo), AllCompositeTableColumnsNoPK, compo, map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NotFound))
+
+			err = sp.UnsafeCompositeRemoveIf(Table, GetCompositeValues(*compo), map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.NotFound))
+		})
+		ginkgo.It("should fail the condition of a register with null columns", func() {
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+
+			err := sp.UnsafeAdd(BasicTable, pk, val, []string{"id1"}, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.UnsafeRemoveIf(BasicTable, pk, val, map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.FailedPrecondition))
+		})
+		ginkgo.It("should be able to remove a register if exists", func() {
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+
+			err := sp.UnsafeAdd(BasicTable, pk, val, AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.UnsafeRemoveIf(BasicTable, pk, val, map[string]interface{}{"id3": uuid.New().String()})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			err = sp.UnsafeRemoveIfExists(BasicTable, pk, val)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.UnsafeRemoveIfExists(BasicTable, pk, val)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
 
 	})
 
@@ -235,5 +435,458 @@ var _ = ginkgo.Describe("Scylla cluster provider", func() {
 			err := sp.UnsafeCompositeRemove(Table, GetCompositeValues(*compo))
 			gomega.Expect(err).NotTo(gomega.Succeed())
 		})
+		ginkgo.It("should be able to add a register if not exists", func() {
+			compo := GetCompositeStruct()
+
+			err := sp.UnsafeCompositeAddIfNotExists(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.UnsafeCompositeAddIfNotExists(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should be able to update a register if exists", func() {
+			compo := GetCompositeStruct()
+
+			err := sp.UnsafeCompositeUpdateIfExists(Table, GetCompositeValues(*compo), AllCompositeTableColumnsNoPK, compo)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			err = sp.UnsafeCompositeAdd(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			compo.Id3 = uuid.New().String()
+			err = sp.UnsafeCompositeUpdateIfExists(Table, GetCompositeValues(*compo), AllCompositeTableColumnsNoPK, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		ginkgo.It("should be able to remove a register if the condition is met", func() {
+			compo := GetCompositeStruct()
+
+			err := sp.UnsafeCompositeAdd(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.UnsafeCompositeRemoveIf(Table, GetCompositeValues(*compo), map[string]interface{}{"id3": uuid.New().String()})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			err = sp.UnsafeCompositeRemoveIf(Table, GetCompositeValues(*compo), map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.UnsafeCompositeRemoveIfExists(Table, GetCompositeValues(*compo))
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should be able to list the registers of a partition page by page", func() {
+			numRegisters := 5
+			partition := uuid.New().String()
+			for i := 0; i < numRegisters; i++ {
+				compo := GetCompositeStruct()
+				compo.Id1 = partition
+				err := sp.UnsafeCompositeAdd(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+				gomega.Expect(err).To(gomega.Succeed())
+			}
+
+			retrieved := make([]CompositeStruct, 0)
+			var pageToken []byte
+			for {
+				page := make([]CompositeStruct, 0)
+				nextToken, err := sp.UnsafeList(Table, map[string]interface{}{"id1": partition}, AllTableColumns, 2, pageToken, &page)
+				gomega.Expect(err).To(gomega.Succeed())
+				gomega.Expect(len(page)).Should(gomega.BeNumerically("<=", 2))
+				retrieved = append(retrieved, page...)
+				if nextToken == nil {
+					break
+				}
+				pageToken = nextToken
+			}
+			gomega.Expect(retrieved).Should(gomega.HaveLen(numRegisters))
+		})
+		ginkgo.It("should be able to scan the whole table", func() {
+			added := make(map[string]bool, 0)
+			for i := 0; i < 10; i++ {
+				compo := GetCompositeStruct()
+				err := sp.UnsafeCompositeAdd(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+				gomega.Expect(err).To(gomega.Succeed())
+				added[compo.Id2] = true
+			}
+
+			var mutex sync.Mutex
+			found := 0
+			checkpoint, err := sp.UnsafeScan(Table, []string{"id1"}, AllTableColumns,
+				func() interface{} { return &CompositeStruct{} },
+				func(row interface{}) error {
+					mutex.Lock()
+					defer mutex.Unlock()
+					if added[row.(*CompositeStruct).Id2] {
+						found++
+					}
+					return nil
+				}, ScanOptions{Splits: 16, Parallelism: 4, PageSize: 2})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(checkpoint.Done()).Should(gomega.BeTrue())
+			gomega.Expect(found).Should(gomega.Equal(len(added)))
+		})
+	})
+
+	ginkgo.Context("Multi-get tests", func() {
+		ginkgo.It("should be able to get several registers by single key", func() {
+			keys := make([]string, 0)
+			added := make([]CompositeStruct, 0)
+			for i := 0; i < 3; i++ {
+				compo := GetCompositeStruct()
+				err := sp.UnsafeAdd(BasicTable, "id1", compo.Id1, AllTableColumns, compo)
+				gomega.Expect(err).To(gomega.Succeed())
+				keys = append(keys, compo.Id1)
+				added = append(added, *compo)
+			}
+			missingKey := uuid.New().String()
+			keys = append(keys, missingKey)
+
+			for _, options := range []MultiGetOptions{{}, {MaxInKeys: -1, Parallelism: 2}} {
+				results := make([]CompositeStruct, 0)
+				missing, err := sp.UnsafeMultiGet(BasicTable, "id1", keys, AllTableColumns, &results, options)
+				gomega.Expect(err).To(gomega.Succeed())
+				gomega.Expect(results).Should(gomega.Equal(added))
+				gomega.Expect(missing).Should(gomega.Equal([]string{missingKey}))
+			}
+		})
+		ginkgo.It("should be able to get several registers by composite key", func() {
+			keys := make([]map[string]interface{}, 0)
+			added := make([]*CompositeStruct, 0)
+			for i := 0; i < 3; i++ {
+				compo := GetCompositeStruct()
+				err := sp.UnsafeCompositeAdd(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+				gomega.Expect(err).To(gomega.Succeed())
+				keys = append(keys, GetCompositeValues(*compo))
+				added = append(added, compo)
+			}
+			missingKey := GetCompositeValues(*GetCompositeStruct())
+			keys = append(keys, missingKey)
+
+			results := make([]*CompositeStruct, 0)
+			missing, err := sp.UnsafeCompositeMultiGet(Table, keys, AllTableColumns, &results, MultiGetOptions{})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(results).Should(gomega.Equal(added))
+			gomega.Expect(missing).Should(gomega.Equal([]map[string]interface{}{missingKey}))
+		})
+	})
+
+	ginkgo.Context("Upsert tests", func() {
+		ginkgo.It("should be able to upsert a register whether it exists or not", func() {
+			compo := GetCompositeStruct()
+			err := sp.UnsafeUpsert(BasicTable, "id1", compo.Id1, AllTableColumnsNoPK, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			compo.Id2 = uuid.New().String()
+			err = sp.UnsafeUpsert(BasicTable, "id1", compo.Id1, AllTableColumnsNoPK, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			var retrieved interface{} = &CompositeStruct{}
+			err = sp.UnsafeGet(BasicTable, "id1", compo.Id1, AllTableColumns, &retrieved)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(compo))
+		})
+		ginkgo.It("should only overwrite the columns listed", func() {
+			compo := GetCompositeStruct()
+			err := sp.UnsafeCompositeAdd(Table, GetCompositeValues(*compo), AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			updated := &CompositeStruct{Id1: compo.Id1, Id2: compo.Id2}
+			err = sp.UnsafeCompositeUpsert(Table, GetCompositeValues(*compo), nil, updated)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			var retrieved interface{} = &CompositeStruct{}
+			err = sp.UnsafeCompositeGet(Table, GetCompositeValues(*compo), AllTableColumns, &retrieved)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(compo))
+		})
+	})
+
+	ginkgo.Context("Batch tests", func() {
+		ginkgo.It("should be able to apply a batch over several tables", func() {
+			compo := GetCompositeStruct()
+			basic := GetCompositeStruct()
+			err := sp.UnsafeAdd(BasicTable, "id1", basic.Id1, AllTableColumns, basic)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = sp.NewBatch(gocql.LoggedBatch).
+				Add(Table, GetCompositeValues(*compo), AllTableColumns, compo).
+				Remove(BasicTable, map[string]interface{}{"id1": basic.Id1}).
+				Exec()
+			gomega.Expect(err).To(gomega.Succeed())
+
+			exists, err := sp.UnsafeGenericCompositeExist(Table, GetCompositeValues(*compo))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(exists).Should(gomega.BeTrue())
+			exists, err = sp.UnsafeGenericExist(BasicTable, "id1", basic.Id1)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(exists).Should(gomega.BeFalse())
+		})
+	})
+
+	ginkgo.Context("Versioned tests", func() {
+		ginkgo.It("should be able to update a register with the expected version", func() {
+			versioned := GetVersionedStruct()
+
+			err := sp.UnsafeAdd(VersionedTable, "id1", versioned.Id1, AllVersionedTableColumns, versioned)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			versioned.Id3 = uuid.New().String()
+			version, err := sp.UnsafeVersionedUpdate(VersionedTable, "id1", versioned.Id1, "version", versioned.Version, AllTableColumnsNoPK, versioned)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(version).Should(gomega.Equal(versioned.Version + 1))
+			versioned.Version = version
+
+			var retrieved interface{} = &VersionedStruct{}
+			err = sp.UnsafeGet(VersionedTable, "id1", versioned.Id1, AllVersionedTableColumns, &retrieved)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(versioned))
+		})
+		ginkgo.It("should not be able to update a register with an old version", func() {
+			versioned := GetVersionedStruct()
+
+			err := sp.UnsafeAdd(VersionedTable, "id1", versioned.Id1, AllVersionedTableColumns, versioned)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			_, err = sp.UnsafeVersionedUpdate(VersionedTable, "id1", versioned.Id1, "version", versioned.Version, AllTableColumnsNoPK, versioned)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			_, err = sp.UnsafeVersionedUpdate(VersionedTable, "id1", versioned.Id1, "version", versioned.Version, AllTableColumnsNoPK, versioned)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should not be able to update a non exists register", func() {
+			versioned := GetVersionedStruct()
+
+			_, err := sp.UnsafeVersionedUpdate(VersionedTable, "id1", versioned.Id1, "version", versioned.Version, AllTableColumnsNoPK, versioned)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+	})
+
+	ginkgo.Context("Repository tests", func() {
+		repository, rErr := NewRepository[CompositeStruct](sp, Table, []string{"id1"}, []string{"id2"})
+		if rErr != nil {
+			ginkgo.Fail("cannot create repository")
+		}
+
+		ginkgo.It("should be able to add and get an entity", func() {
+			compo := GetCompositeStruct()
+			err := repository.Add(compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			exists, err := repository.Exists(repository.Key(compo))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(exists).Should(gomega.BeTrue())
+
+			retrieved, err := repository.Get(repository.Key(compo))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(compo))
+		})
+		ginkgo.It("should be able to update an entity", func() {
+			compo := GetCompositeStruct()
+			err := repository.Add(compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			compo.Id3 = uuid.New().String()
+			err = repository.Update(compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			retrieved, err := repository.Get(repository.Key(compo))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(compo))
+		})
+		ginkgo.It("should be able to use entities whose fields are renamed by the tags", func() {
+			renamed, err := NewTaggedRepository[RenamedStruct](sp, Table)
+			gomega.Expect(err).To(gomega.Succeed())
+			entity := &RenamedStruct{Key: uuid.New().String(), Id2: uuid.New().String(), Label: uuid.New().String()}
+			err = renamed.Add(entity)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			entity.Label = uuid.New().String()
+			err = renamed.Update(entity)
+			gomega.Expect(err).To(gomega.Succeed())
+			err = renamed.Upsert(entity)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			retrieved, err := renamed.Get(renamed.Key(entity))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(entity))
+			list, _, err := renamed.List(map[string]interface{}{"id1": entity.Key}, 10, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).Should(gomega.Equal([]RenamedStruct{*entity}))
+		})
+		ginkgo.It("should be able to update the fields of a field mask", func() {
+			compo := GetCompositeStruct()
+			err := repository.Add(compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			compo.Id3 = uuid.New().String()
+			err = repository.UpdateFields(compo, []string{"id3"})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = repository.UpdateFields(compo, []string{"id2"})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			retrieved, err := repository.Get(repository.Key(compo))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(compo))
+		})
+		ginkgo.It("should be able to get entities that do not map all the columns", func() {
+			compo := GetCompositeStruct()
+			err := repository.Add(compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			partial, err := NewTaggedRepository[PartialStruct](sp, Table)
+			gomega.Expect(err).To(gomega.Succeed())
+			expected := &PartialStruct{Id1: compo.Id1, Id2: compo.Id2}
+			retrieved, err := partial.Get(partial.Key(expected))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(expected))
+
+			entity := &PartialStruct{Id1: compo.Id1, Id2: compo.Id2}
+			err = sp.UnsafeEntityGet(partial.Metadata(), entity)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(entity).Should(gomega.Equal(expected))
+		})
+		ginkgo.It("should be able to update the fields of a field mask renamed by the tags", func() {
+			masked, err := NewTaggedRepository[MaskedStruct](sp, MaskedTable)
+			gomega.Expect(err).To(gomega.Succeed())
+			err = sp.CreateTable(context.Background(), masked.Metadata())
+			gomega.Expect(err).To(gomega.Succeed())
+			entity := &MaskedStruct{Id1: uuid.New().String(), DisplayName: "name", Id3: "id3"}
+			err = masked.Add(entity)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			updated := &MaskedStruct{Id1: entity.Id1, DisplayName: "updated", Id3: "ignored"}
+			err = masked.UpdateFields(updated, []string{"display_name"})
+			gomega.Expect(err).To(gomega.Succeed())
+			entity.DisplayName = updated.DisplayName
+			retrieved, err := masked.Get(masked.Key(entity))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(entity))
+
+			updated.DisplayName = "updated again"
+			err = sp.UnsafeEntityUpdateFields(masked.Metadata(), updated, []string{"name"})
+			gomega.Expect(err).To(gomega.Succeed())
+			entity.DisplayName = updated.DisplayName
+			retrieved, err = masked.Get(masked.Key(entity))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(entity))
+		})
+		ginkgo.It("should be able to remove an entity", func() {
+			compo := GetCompositeStruct()
+			err := repository.Add(compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = repository.Remove(repository.Key(compo))
+			gomega.Expect(err).To(gomega.Succeed())
+
+			_, err = repository.Get(repository.Key(compo))
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should be able to list the entities of a partition", func() {
+			partition := uuid.New().String()
+			for i := 0; i < 3; i++ {
+				compo := GetCompositeStruct()
+				compo.Id1 = partition
+				err := repository.Add(compo)
+				gomega.Expect(err).To(gomega.Succeed())
+			}
+
+			list, nextToken, err := repository.List(map[string]interface{}{"id1": partition}, 10, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).Should(gomega.HaveLen(3))
+			gomega.Expect(nextToken).Should(gomega.BeNil())
+		})
+		ginkgo.It("should be able to use the metadata of a tagged struct", func() {
+			md, err := NewTableMetadata(Table, &TaggedStruct{})
+			gomega.Expect(err).To(gomega.Succeed())
+
+			tagged := &TaggedStruct{Id1: uuid.New().String(), Id2: uuid.New().String(), Id3: uuid.New().String()}
+			err = sp.UnsafeEntityAdd(md, tagged)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			tagged.Id3 = uuid.New().String()
+			err = sp.UnsafeEntityUpdate(md, tagged)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			retrieved := &TaggedStruct{Id1: tagged.Id1, Id2: tagged.Id2}
+			err = sp.UnsafeEntityGet(md, retrieved)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(tagged))
+
+			err = sp.UnsafeEntityRemove(md, tagged)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+	})
+
+	ginkgo.Context("Concurrency tests", func() {
+		ginkgo.It("should be able to reconnect from several goroutines", func() {
+			// all the goroutines find the session closed, only one of them reconnects
+			sp.Disconnect()
+
+			numGoroutines := 10
+			errors := make(chan error, numGoroutines)
+			var wg sync.WaitGroup
+			for i := 0; i < numGoroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					compo := GetCompositeStruct()
+					pk, val := GetValues(*compo)
+					if err := sp.UnsafeAdd(BasicTable, pk, val, AllTableColumns, compo); err != nil {
+						errors <- err
+						return
+					}
+					var retrieved interface{} = &CompositeStruct{}
+					if err := sp.UnsafeGet(BasicTable, pk, val, AllTableColumns, &retrieved); err != nil {
+						errors <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errors)
+			for err := range errors {
+				gomega.Expect(err).To(gomega.Succeed())
+			}
+			gomega.Expect(sp.CheckConnection()).To(gomega.Succeed())
+		})
+	})
+
+	ginkgo.Context("Context tests", func() {
+		ginkgo.It("should be able to use a context", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			compo := GetCompositeStruct()
+			err := sp.UnsafeCompositeAddContext(ctx, Table, GetCompositeValues(*compo), AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			var retrieved interface{} = &CompositeStruct{}
+			err = sp.UnsafeCompositeGetContext(ctx, Table, GetCompositeValues(*compo), AllTableColumns, &retrieved)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved).Should(gomega.Equal(compo))
+		})
+		ginkgo.It("should not be able to use a canceled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			compo := GetCompositeStruct()
+			pk, val := GetValues(*compo)
+			err := sp.UnsafeAddContext(ctx, BasicTable, pk, val, AllTableColumns, compo)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should be able to use a context in lightweight transactions", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			compo := GetCompositeStruct()
+			err := sp.UnsafeCompositeAddIfNotExistsContext(ctx, Table, GetCompositeValues(*compo), AllTableColumns, compo)
+			gomega.Expect(err).To(gomega.Succeed())
+			err = sp.UnsafeCompositeUpdateIfContext(ctx, Table, GetCompositeValues(*compo), AllCompositeTableColumnsNoPK, compo, map[string]interface{}{"id3": compo.Id3})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = sp.UnsafeCompositeRemoveIfExistsContext(ctx, Table, GetCompositeValues(*compo))
+			gomega.Expect(err).To(gomega.Succeed())
+
+			canceled, cancelNow := context.WithCancel(context.Background())
+			cancelNow()
+			err = sp.UnsafeCompositeRemoveIfExistsContext(canceled, Table, GetCompositeValues(*compo))
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.Canceled))
+		})
 	})
 })
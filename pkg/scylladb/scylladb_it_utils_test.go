@@ -0,0 +1,65 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/google/uuid"
+	"github.com/nalej/derrors"
+	"os"
+)
+
+type VersionedStruct struct {
+	Id1     string `json:"id1,omitempty" cql:"id1"`
+	Id2     string `json:"id2,omitempty" cql:"id2"`
+	Id3     string `json:"id3,omitempty" cql:"id3"`
+	Version int64  `json:"version,omitempty" cql:"version"`
+}
+
+var AllVersionedTableColumns = []string{"id1", "id2", "id3", "version"}
+
+const VersionedTable = "versionedtabletest"
+
+func GetVersionedStruct() *VersionedStruct {
+
+	return &VersionedStruct{
+		Id1:     uuid.New().String(),
+		Id2:     uuid.New().String(),
+		Id3:     uuid.New().String(),
+		Version: 1,
+	}
+}
+
+// legacyIntegrationEnv maps the variables read by former versions of the integration tests to the current ones.
+var legacyIntegrationEnv = map[string]string{
+	"IT_SCYLLA_HOST":    "IT_SCYLLA_HOSTS",
+	"IT_NALEJ_KEYSPACE": "IT_SCYLLA_KEYSPACE",
+}
+
+// integrationTestConfig builds the configuration of the integration tests from the IT_SCYLLA_ variables, falling
+// back to the legacy variables if the current ones are not set.
+func integrationTestConfig() (*Config, derrors.Error) {
+	for legacy, current := range legacyIntegrationEnv {
+		if _, exists := os.LookupEnv(current); exists {
+			continue
+		}
+		if value, exists := os.LookupEnv(legacy); exists {
+			os.Setenv(current, value)
+		}
+	}
+	return ConfigFromEnv("IT_SCYLLA_")
+}
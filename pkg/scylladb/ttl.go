@@ -0,0 +1,199 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/scylladb/gocqlx/qb"
+	"time"
+)
+
+// The TTL and the timestamp of a write are bound as named parameters, so the statement is prepared once whatever
+// their values are.
+
+// Names of the parameters of the TTL and the timestamp of a write.
+const (
+	ttlParam       = "_ttl"
+	timestampParam = "_ts"
+)
+
+// WriteOption sets an option of the add and update functions.
+type WriteOption func(*writeOptions)
+
+// writeOptions contains the options of a write.
+type writeOptions struct {
+	ttl       *time.Duration
+	timestamp *time.Time
+	// invalid is the error of the first invalid option.
+	invalid derrors.Error
+}
+
+// WithTTL sets the time to live of the columns written, truncated to seconds. A zero TTL means that they do not
+// expire.
+func WithTTL(ttl time.Duration) WriteOption {
+	return func(o *writeOptions) {
+		if ttl < 0 || (ttl > 0 && ttl < time.Second) {
+			o.fail(derrors.NewInvalidArgumentError("TTL must be zero or at least one second").WithParams(ttl.String()))
+			return
+		}
+		o.ttl = &ttl
+	}
+}
+
+// WithTimestamp sets the timestamp of the write instead of the one assigned by the coordinator. The columns are only
+// overwritten by writes with a later timestamp, so the timestamp must be after the Unix epoch.
+func WithTimestamp(timestamp time.Time) WriteOption {
+	return func(o *writeOptions) {
+		if timestamp.Before(time.Unix(0, int64(time.Microsecond))) {
+			o.fail(derrors.NewInvalidArgumentError("timestamp must be after the Unix epoch").WithParams(timestamp.String()))
+			return
+		}
+		o.timestamp = &timestamp
+	}
+}
+
+// newWriteOptions applies a list of options, checking that they are valid.
+func newWriteOptions(options []WriteOption) (*writeOptions, derrors.Error) {
+	result := &writeOptions{}
+	for _, option := range options {
+		option(result)
+	}
+	if result.invalid != nil {
+		return nil, result.invalid
+	}
+	return result, nil
+}
+
+// fail records the error of an invalid option, keeping the first one.
+func (o *writeOptions) fail(err derrors.Error) {
+	if o.invalid == nil {
+		o.invalid = err
+	}
+}
+
+// insert adds the USING clause of the options to an insert statement.
+func (o *writeOptions) insert(ib *qb.InsertBuilder) *qb.InsertBuilder {
+	if o.ttl != nil {
+		ib = ib.TTLNamed(ttlParam)
+	}
+	if o.timestamp != nil {
+		ib = ib.TimestampNamed(timestampParam)
+	}
+	return ib
+}
+
+// update adds the USING clause of the options to an update statement.
+func (o *writeOptions) update(ub *qb.UpdateBuilder) *qb.UpdateBuilder {
+	if o.ttl != nil {
+		ub = ub.TTLNamed(ttlParam)
+	}
+	if o.timestamp != nil {
+		ub = ub.TimestampNamed(timestampParam)
+	}
+	return ub
+}
+
+// values returns the values of the parameters of the USING clause.
+func (o *writeOptions) values() qb.M {
+	values := qb.M{}
+	if o.ttl != nil {
+		values[ttlParam] = qb.TTL(*o.ttl)
+	}
+	if o.timestamp != nil {
+		values[timestampParam] = qb.Timestamp(*o.timestamp)
+	}
+	return values
+}
+
+// WriteMetadata contains the TTL and the write time of a column.
+type WriteMetadata struct {
+	// TTL is the remaining time to live of the column, zero if it does not expire.
+	TTL time.Duration
+	// WriteTime is the timestamp of the last write of the column, zero if the column is null.
+	WriteTime time.Time
+}
+
+// UnsafeWriteMetadata reads the TTL and the write time of some columns of an element identified by a single primary
+// key, indexed by column name. The columns of the primary key have no write metadata.
+func (s *ScyllaDB) UnsafeWriteMetadata(table string, pkColumn string, pkValue string, tableColumnNames []string) (map[string]WriteMetadata, derrors.Error) {
+	return s.UnsafeWriteMetadataContext(context.Background(), table, pkColumn, pkValue, tableColumnNames)
+}
+
+// UnsafeWriteMetadataContext is the context-aware version of UnsafeWriteMetadata. The context is passed to all the
+// queries.
+func (s *ScyllaDB) UnsafeWriteMetadataContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string) (map[string]WriteMetadata, derrors.Error) {
+	return s.UnsafeCompositeWriteMetadataContext(ctx, table, map[string]interface{}{pkColumn: pkValue}, tableColumnNames)
+}
+
+// UnsafeCompositeWriteMetadata reads the TTL and the write time of some columns of an element identified by a
+// composite primary key, indexed by column name. The columns of the primary key have no write metadata.
+func (s *ScyllaDB) UnsafeCompositeWriteMetadata(table string, pkColumn map[string]interface{}, tableColumnNames []string) (map[string]WriteMetadata, derrors.Error) {
+	return s.UnsafeCompositeWriteMetadataContext(context.Background(), table, pkColumn, tableColumnNames)
+}
+
+// UnsafeCompositeWriteMetadataContext is the context-aware version of UnsafeCompositeWriteMetadata. The context is
+// passed to all the queries.
+func (s *ScyllaDB) UnsafeCompositeWriteMetadataContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string) (map[string]WriteMetadata, derrors.Error) {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := qb.Select(table)
+	ttls := make([]*int, len(tableColumnNames))
+	writeTimes := make([]*int64, len(tableColumnNames))
+	dest := make([]interface{}, 0, 2*len(tableColumnNames))
+	for i, c := range tableColumnNames {
+		sb = sb.Columns(fmt.Sprintf("TTL(%s)", c), fmt.Sprintf("WRITETIME(%s)", c))
+		dest = append(dest, &ttls[i], &writeTimes[i])
+	}
+	keys := sortedKeys(pkColumn)
+	values := make([]interface{}, 0, len(keys))
+	for _, p := range keys {
+		sb = sb.Where(qb.Eq(p))
+		values = append(values, pkColumn[p])
+	}
+	stmt, _ := sb.ToCql()
+
+	err = s.execute(ctx, session, GetOperation, stmt, "cannot get write metadata", func(q *gocql.Query) error {
+		return q.Bind(values...).Scan(dest...)
+	})
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, derrors.NewNotFoundError(table).WithParams(getParams(pkColumn))
+		}
+		return nil, err
+	}
+
+	result := make(map[string]WriteMetadata, len(tableColumnNames))
+	for i, c := range tableColumnNames {
+		var metadata WriteMetadata
+		if ttls[i] != nil {
+			metadata.TTL = time.Duration(*ttls[i]) * time.Second
+		}
+		if writeTimes[i] != nil {
+			metadata.WriteTime = time.Unix(0, *writeTimes[i]*int64(time.Microsecond))
+		}
+		result[c] = metadata
+	}
+	return result, nil
+}
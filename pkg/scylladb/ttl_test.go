@@ -0,0 +1,69 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/scylladb/gocqlx/qb"
+	"time"
+)
+
+var _ = ginkgo.Describe("Write options", func() {
+
+	ginkgo.It("should add the USING clause to the statements", func() {
+		timestamp := time.Unix(1500000000, 0)
+		options, err := newWriteOptions([]WriteOption{WithTTL(time.Hour), WithTimestamp(timestamp)})
+		gomega.Expect(err).To(gomega.Succeed())
+
+		stmt, names := options.insert(qb.Insert(BasicTable).Columns(AllTableColumns...)).ToCql()
+		gomega.Expect(stmt).Should(gomega.Equal("INSERT INTO basictabletest (id1,id2,id3) VALUES (?,?,?) USING TTL ? AND TIMESTAMP ? "))
+		gomega.Expect(names).Should(gomega.Equal([]string{"id1", "id2", "id3", ttlParam, timestampParam}))
+
+		stmt, _ = options.update(qb.Update(BasicTable).Set(AllTableColumnsNoPK...)).Where(qb.Eq("id1")).ToCql()
+		gomega.Expect(stmt).Should(gomega.Equal("UPDATE basictabletest USING TTL ? AND TIMESTAMP ? SET id2=?,id3=? WHERE id1=? "))
+
+		gomega.Expect(options.values()).Should(gomega.Equal(qb.M{ttlParam: int64(3600), timestampParam: timestamp.UnixNano() / 1000}))
+	})
+	ginkgo.It("should not change the statements without options", func() {
+		options, err := newWriteOptions(nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		stmt, _ := options.insert(qb.Insert(BasicTable).Columns(AllTableColumns...)).ToCql()
+		gomega.Expect(stmt).Should(gomega.Equal("INSERT INTO basictabletest (id1,id2,id3) VALUES (?,?,?) "))
+		gomega.Expect(options.values()).Should(gomega.BeEmpty())
+	})
+	ginkgo.It("should reject invalid TTLs", func() {
+		_, err := newWriteOptions([]WriteOption{WithTTL(-time.Second)})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.InvalidArgument))
+		_, err = newWriteOptions([]WriteOption{WithTTL(time.Millisecond)})
+		gomega.Expect(err).ShouldNot(gomega.Succeed())
+		_, err = newWriteOptions([]WriteOption{WithTTL(0)})
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.It("should reject timestamps that are not after the epoch", func() {
+		for _, timestamp := range []time.Time{{}, time.Unix(0, 0), time.Unix(-1, 0)} {
+			_, err := newWriteOptions([]WriteOption{WithTimestamp(timestamp)})
+			gomega.Expect(err).ShouldNot(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.InvalidArgument))
+		}
+		_, err := newWriteOptions([]WriteOption{WithTimestamp(time.Unix(0, int64(time.Microsecond)))})
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/scylladb/go-reflectx"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// An upsert is a single INSERT that creates the element if it does not exist and overwrites it otherwise, without
+// checking its existence first. Only the columns of the primary key and the columns listed are written, so the
+// columns that are not listed keep their values.
+
+// UnsafeUpsert inserts or overwrites some columns of an element identified by a single primary key.
+func (s *ScyllaDB) UnsafeUpsert(table string, pkColumn string, pkValue string, tableColumnNames []string, toUpsert interface{}, options ...WriteOption) derrors.Error {
+	return s.UnsafeUpsertContext(context.Background(), table, pkColumn, pkValue, tableColumnNames, toUpsert, options...)
+}
+
+// UnsafeUpsertContext is the context-aware version of UnsafeUpsert. The context is passed to the query.
+func (s *ScyllaDB) UnsafeUpsertContext(ctx context.Context, table string, pkColumn string, pkValue string, tableColumnNames []string, toUpsert interface{}, options ...WriteOption) derrors.Error {
+	return s.UnsafeCompositeUpsertContext(ctx, table, map[string]interface{}{pkColumn: pkValue}, tableColumnNames, toUpsert, options...)
+}
+
+// UnsafeCompositeUpsert inserts or overwrites some columns of an element identified by a composite primary key.
+func (s *ScyllaDB) UnsafeCompositeUpsert(table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpsert interface{}, options ...WriteOption) derrors.Error {
+	return s.UnsafeCompositeUpsertContext(context.Background(), table, pkColumn, tableColumnNames, toUpsert, options...)
+}
+
+// UnsafeCompositeUpsertContext is the context-aware version of UnsafeCompositeUpsert. The context is passed to the
+// query.
+func (s *ScyllaDB) UnsafeCompositeUpsertContext(ctx context.Context, table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpsert interface{}, options ...WriteOption) derrors.Error {
+	return s.compositeUpsert(ctx, gocqlx.DefaultMapper, table, pkColumn, tableColumnNames, toUpsert, options)
+}
+
+// compositeUpsert inserts or overwrites an element, binding the columns from the fields of toUpsert resolved by the
+// mapper.
+func (s *ScyllaDB) compositeUpsert(ctx context.Context, mapper *reflectx.Mapper, table string, pkColumn map[string]interface{}, tableColumnNames []string, toUpsert interface{}, options []WriteOption) derrors.Error {
+	// check connection
+	session, err := s.getSession(ctx)
+	if err != nil {
+		return err
+	}
+	writeOptions, err := newWriteOptions(options)
+	if err != nil {
+		return err
+	}
+
+	// the values of the primary key are taken from the map if the struct does not contain them
+	values := writeOptions.values()
+	for column, value := range pkColumn {
+		values[column] = value
+	}
+	stmt, names := writeOptions.insert(qb.Insert(table).Columns(upsertColumns(pkColumn, tableColumnNames)...)).ToCql()
+	return s.execute(ctx, session, UpsertOperation, stmt, "cannot upsert element", func(q *gocql.Query) error {
+		return bindQuery(q, names, mapper).BindStructMap(toUpsert, values).ExecRelease()
+	})
+}
+
+// upsertColumns returns the columns written by an upsert: the columns of the primary key followed by the rest of the
+// columns listed.
+func upsertColumns(pkColumn map[string]interface{}, tableColumnNames []string) []string {
+	columns := sortedKeys(pkColumn)
+	for _, c := range tableColumnNames {
+		if _, isKey := pkColumn[c]; !isKey {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package scylladb
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+var _ = ginkgo.Describe("Upsert", func() {
+
+	ginkgo.It("should write the primary key before the columns listed", func() {
+		key := map[string]interface{}{"id2": "b", "id1": "a"}
+		gomega.Expect(upsertColumns(key, AllCompositeTableColumnsNoPK)).Should(gomega.Equal([]string{"id1", "id2", "id3"}))
+		gomega.Expect(upsertColumns(key, []string{"id3", "id1"})).Should(gomega.Equal([]string{"id1", "id2", "id3"}))
+		gomega.Expect(upsertColumns(key, nil)).Should(gomega.Equal([]string{"id1", "id2"}))
+	})
+	ginkgo.It("should be idempotent", func() {
+		stmt, _ := qb.Insert(Table).Columns(upsertColumns(map[string]interface{}{"id1": "a"}, AllTableColumns)...).ToCql()
+		gomega.Expect(IsIdempotent(stmt)).Should(gomega.BeTrue())
+	})
+})